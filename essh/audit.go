@@ -0,0 +1,112 @@
+package essh
+
+import (
+	"encoding/json"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// AuditLogFile is a path to the audit log. If it is empty, essh does not write audit logs.
+var AuditLogFile string
+
+// LoadedConfigFiles are the config files that are loaded in the current process.
+var LoadedConfigFiles []string
+
+// AuditRecord is a line of the audit log that is written as JSON lines.
+type AuditRecord struct {
+	Type        string         `json:"type"`
+	User        string         `json:"user"`
+	WorkingDir  string         `json:"working_dir"`
+	ConfigFiles []string       `json:"config_files"`
+	Task        string         `json:"task,omitempty"`
+	Command     string         `json:"command,omitempty"`
+	Args        []string       `json:"args"`
+	Hosts       []string       `json:"hosts"`
+	StartTime   time.Time      `json:"start_time"`
+	EndTime     time.Time      `json:"end_time"`
+	ExitCodes   map[string]int `json:"exit_codes"`
+	Error       string         `json:"error,omitempty"`
+}
+
+func NewAuditRecord(recordType string) *AuditRecord {
+	return &AuditRecord{
+		Type:        recordType,
+		User:        currentUsername(),
+		WorkingDir:  WorkingDir,
+		ConfigFiles: LoadedConfigFiles,
+		Args:        []string{},
+		Hosts:       []string{},
+		ExitCodes:   map[string]int{},
+	}
+}
+
+func NewTaskAuditRecord(result *TaskResult) *AuditRecord {
+	record := NewAuditRecord("task")
+	record.Task = result.Task.Name
+	if result.Task.Name == ExecTaskName {
+		// record the commands that are passed by --exec
+		if result.Task.File != "" {
+			record.Command = result.Task.File
+		} else {
+			codes := []string{}
+			for _, script := range result.Task.Script {
+				codes = append(codes, script["code"])
+			}
+			record.Command = Secrets.Mask(strings.Join(codes, "\n"))
+		}
+	}
+	record.Args = maskArgs(result.Args)
+	record.Hosts = result.HostNames()
+	record.StartTime = result.StartTime
+	record.EndTime = result.EndTime
+	record.ExitCodes = result.ExitCodes()
+	if result.Error != nil {
+		record.Error = Secrets.Mask(result.Error.Error())
+	}
+
+	return record
+}
+
+func WriteAuditLog(record *AuditRecord) error {
+	if AuditLogFile == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(AuditLogFile), os.FileMode(0755)); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(AuditLogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
+
+func maskArgs(args []string) []string {
+	masked := []string{}
+	for _, arg := range args {
+		masked = append(masked, Secrets.Mask(arg))
+	}
+
+	return masked
+}
+
+func currentUsername() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+
+	return os.Getenv("USER")
+}
@@ -0,0 +1,134 @@
+package essh
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunTaskWritesAuditLogOnEarlyErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		code  string
+		args  []string
+		error string
+	}{
+		{
+			name:  "invalid params",
+			code:  `task "deploy" { params = { env = { required = true } }, script = "echo" }`,
+			args:  []string{},
+			error: "task 'deploy' requires a parameter '--env'.",
+		},
+		{
+			name:  "no hosts",
+			code:  `task "deploy" { backend = "remote", targets = "web", script = "echo" }`,
+			args:  []string{"a"},
+			error: "There are not hosts to run the command.",
+		},
+		{
+			name:  "prepare failure",
+			code:  `task "deploy" { prepare = function() return false end, script = "echo" }`,
+			args:  []string{},
+			error: "returned false from the prepare function.",
+		},
+	}
+
+	dir, err := ioutil.TempDir("", "essh-audit-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, c := range cases {
+		L := newTestLState()
+		AuditLogFile = filepath.Join(dir, c.name+".log")
+		HistoryFile = filepath.Join(dir, c.name+".history")
+
+		if err := L.DoString(c.code); err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.name, err)
+		}
+
+		runErr := runTask(filepath.Join(dir, "ssh_config"), Tasks["deploy"], c.args, L)
+		L.Close()
+		if runErr == nil {
+			t.Errorf("%s: expected an error", c.name)
+			continue
+		}
+
+		b, err := ioutil.ReadFile(AuditLogFile)
+		if err != nil {
+			t.Errorf("%s: audit log was not written: %v", c.name, err)
+			continue
+		}
+
+		record := &AuditRecord{}
+		if err := json.Unmarshal(b, record); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+
+		if record.Task != "deploy" || record.Error != runErr.Error() || strings.Join(record.Args, " ") != strings.Join(c.args, " ") {
+			t.Errorf("%s: unexpected record: %+v", c.name, record)
+		}
+		if c.error != "" && !strings.Contains(record.Error, c.error) {
+			t.Errorf("%s: expected error %q but got %q", c.name, c.error, record.Error)
+		}
+	}
+
+	AuditLogFile = ""
+}
+
+func TestRunTaskWithoutRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "essh-audit-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	L := newTestLState()
+	defer L.Close()
+
+	AuditLogFile = filepath.Join(dir, "audit.log")
+	HistoryFile = filepath.Join(dir, "history")
+	defer func() {
+		AuditLogFile = ""
+		HistoryFile = ""
+	}()
+
+	if err := L.DoString(`task "deploy" { script = "echo" }`); err != nil {
+		t.Fatal(err)
+	}
+
+	runs := 0
+	runner := func(config string, task *Task, hosts []*Host, result *TaskResult) error {
+		runs++
+		return nil
+	}
+
+	for _, record := range []bool{true, false, false} {
+		if err := runTaskWithScriptsRunner(filepath.Join(dir, "ssh_config"), Tasks["deploy"], []string{}, L, runner, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if runs != 3 {
+		t.Errorf("expected the runner to run 3 times but got %d", runs)
+	}
+
+	records, err := LoadHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Errorf("expected 1 history record but got %d", len(records))
+	}
+
+	b, err := ioutil.ReadFile(AuditLogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(b), "\n"); lines != 1 {
+		t.Errorf("expected 1 audit record but got %d", lines)
+	}
+}
@@ -0,0 +1,129 @@
+package essh
+
+import (
+	"fmt"
+	"github.com/yuin/gopher-lua"
+	"strings"
+)
+
+// ConfigError is a problem of a resource definition in the config files.
+type ConfigError struct {
+	Source   string
+	Resource string
+	Name     string
+	Field    string
+	Expected string
+	Actual   lua.LValue
+	Message  string
+}
+
+func (e *ConfigError) Error() string {
+	msg := e.Message
+	if msg == "" && e.Expected != "" {
+		msg = fmt.Sprintf("'%s' must be %s but got %s.", e.Field, e.Expected, describeLValue(e.Actual))
+	} else if msg == "" {
+		msg = fmt.Sprintf("unsupported field '%s'.", e.Field)
+	}
+
+	subject := e.Resource
+	if e.Name != "" {
+		subject += " '" + e.Name + "'"
+	}
+
+	if e.Source == "" {
+		return subject + ": " + msg
+	}
+
+	return e.Source + ": " + subject + ": " + msg
+}
+
+// ConfigErrors are the problems that are found in loading the config files.
+type ConfigErrors []*ConfigError
+
+func (errs ConfigErrors) Error() string {
+	lines := []string{fmt.Sprintf("found %d problems in the config:", len(errs))}
+	for _, err := range errs {
+		lines = append(lines, "  "+err.Error())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// LoadingConfig is true while essh loads the config files.
+// In loading, the problems are collected to CollectedConfigErrors and reported together.
+// Otherwise (ex. in the prepare functions), the problems are raised as Lua errors immediately.
+var LoadingConfig bool
+
+var CollectedConfigErrors ConfigErrors
+
+func addConfigError(L *lua.LState, err *ConfigError) {
+	// prefer the position that is running now, because the fields can be updated after the definition.
+	if pos := luaSourcePosition(L); pos != "" {
+		err.Source = pos
+	}
+
+	if !LoadingConfig {
+		// RaiseError adds the source position.
+		e := *err
+		e.Source = ""
+		L.RaiseError("%s", e.Error())
+		return
+	}
+
+	CollectedConfigErrors = append(CollectedConfigErrors, err)
+}
+
+// fieldError reports the invalid value of the field of the resource. If expected is empty, the field is unsupported.
+func fieldError(L *lua.LState, resource string, name string, source string, key string, expected string, value lua.LValue) {
+	addConfigError(L, &ConfigError{
+		Source:   source,
+		Resource: resource,
+		Name:     name,
+		Field:    key,
+		Expected: expected,
+		Actual:   value,
+	})
+}
+
+// fieldErrorWithMessage reports the problem of the field of the resource by the message.
+func fieldErrorWithMessage(L *lua.LState, resource string, name string, source string, key string, msg string) {
+	addConfigError(L, &ConfigError{
+		Source:   source,
+		Resource: resource,
+		Name:     name,
+		Field:    key,
+		Message:  msg,
+	})
+}
+
+// argumentsError reports the invalid arguments of the function that defines the resource.
+func argumentsError(L *lua.LState, resource string, msg string) {
+	addConfigError(L, &ConfigError{
+		Resource: resource,
+		Message:  msg,
+	})
+}
+
+// configLoadError returns the error of loading a config file with the problems that are collected before it.
+func configLoadError(err error) error {
+	if len(CollectedConfigErrors) == 0 {
+		return err
+	}
+
+	return fmt.Errorf("%v\n%v", CollectedConfigErrors, err)
+}
+
+func describeLValue(value lua.LValue) string {
+	switch v := value.(type) {
+	case nil:
+		return "nil"
+	case lua.LString:
+		return fmt.Sprintf("string %q", string(v))
+	case lua.LNumber:
+		return "number " + v.String()
+	case lua.LBool:
+		return "boolean " + v.String()
+	default:
+		return value.Type().String()
+	}
+}
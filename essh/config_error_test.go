@@ -0,0 +1,106 @@
+package essh
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigErrors(t *testing.T) {
+	cases := []struct {
+		name   string
+		code   string
+		errors []string
+	}{
+		{
+			name:   "valid config",
+			code:   `host "web01" { HostName = "192.168.0.11", env = { FOO = "bar" } }`,
+			errors: []string{},
+		},
+		{
+			name: "invalid host fields",
+			code: `
+host "web01" { HostName = 1 }
+host "web02" { unknown = "x" }
+`,
+			errors: []string{
+				"host 'web01': 'HostName' must be a string (ssh_config) but got number 1.",
+				"host 'web02': unsupported field 'unknown'.",
+			},
+		},
+		{
+			name: "invalid env",
+			code: `
+host "web01" { env = { ["1FOO"] = "bar" } }
+task "deploy" { script = "echo", env = { FOO = {} } }
+`,
+			errors: []string{
+				`host 'web01': 'env' key must be a valid environment variable name but got string "1FOO".`,
+				"task 'deploy': 'env' value of 'FOO' must be a string but got table.",
+			},
+		},
+		{
+			name:   "invalid driver engine",
+			code:   `driver "mydriver" { engine = 1 }`,
+			errors: []string{"driver 'mydriver': 'engine' must be a function or string but got number 1."},
+		},
+		{
+			name: "invalid arguments",
+			code: `
+host("web01", {}, {})
+host(1)
+task("deploy", {}, {})
+tunnel("db", {}, {})
+driver("mydriver", {}, {})
+`,
+			errors: []string{
+				"host: requires 1 or 2 arguments.",
+				"host: the first argument must be a table or string but got number 1.",
+				"task: requires 1 or 2 arguments.",
+				"tunnel: requires 1 or 2 arguments.",
+				"driver: requires 1 or 2 arguments.",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		L := newTestLState()
+		err := L.DoString(c.code)
+		L.Close()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+
+		if len(CollectedConfigErrors) != len(c.errors) {
+			t.Errorf("%s: expected %d errors but got %v", c.name, len(c.errors), CollectedConfigErrors)
+			continue
+		}
+
+		for i, expected := range c.errors {
+			if actual := CollectedConfigErrors[i].Error(); !strings.HasSuffix(actual, expected) {
+				t.Errorf("%s: expected error %q but got %q", c.name, expected, actual)
+			}
+		}
+	}
+}
+
+func TestConfigErrorsAfterLoading(t *testing.T) {
+	L := newTestLState()
+	defer L.Close()
+
+	if err := L.DoString(`web01 = host "web01" { HostName = "192.168.0.11" }`); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	LoadingConfig = false
+	err := L.DoString(`web01.Port = {}`)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	if !strings.Contains(err.Error(), "host 'web01': 'Port' must be a string (ssh_config) but got table.") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(CollectedConfigErrors) != 0 {
+		t.Errorf("expected no collected errors but got %v", CollectedConfigErrors)
+	}
+}
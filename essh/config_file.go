@@ -0,0 +1,259 @@
+package essh
+
+import (
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/yuin/gopher-lua"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// DataConfigFileExtensions are the extensions of the config files that are written in YAML or TOML instead of Lua.
+// These files are loaded before the Lua config file that has the same name (ex. ".esshconfig.yaml" and ".esshconfig.lua").
+var DataConfigFileExtensions = []string{".yaml", ".yml", ".toml"}
+
+// CurrentDataConfigFile is the YAML or TOML config file that is being loaded.
+// It is used as the source position of the resources, because they are not defined by Lua code.
+var CurrentDataConfigFile string
+
+func isDataConfigFile(path string) bool {
+	ext := filepath.Ext(path)
+	for _, dataExt := range DataConfigFileExtensions {
+		if ext == dataExt {
+			return true
+		}
+	}
+
+	return false
+}
+
+// configFiles returns the existing config files that are loaded for the config file path.
+func configFiles(path string) []string {
+	ext := filepath.Ext(path)
+	if ext != ".lua" && !isDataConfigFile(path) {
+		// custom file name by --config option.
+		if _, err := os.Stat(path); err == nil {
+			return []string{path}
+		}
+		return []string{}
+	}
+
+	base := strings.TrimSuffix(path, ext)
+	candidates := []string{}
+	for _, dataExt := range DataConfigFileExtensions {
+		candidates = append(candidates, base+dataExt)
+	}
+	candidates = append(candidates, base+".lua")
+
+	files := []string{}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			files = append(files, candidate)
+		}
+	}
+
+	return files
+}
+
+// findWorkingDirConfigFile looks for the config file from the directory up to the root like git.
+// If no config file is found, it returns ".esshconfig.lua" in the directory.
+func findWorkingDirConfigFile(dir string) string {
+	for d := dir; ; d = filepath.Dir(d) {
+		// "esshconfig.lua" is for backward compatibility.
+		for _, name := range []string{"esshconfig.lua", ".esshconfig.lua"} {
+			if path := filepath.Join(d, name); len(configFiles(path)) > 0 {
+				return path
+			}
+		}
+
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+
+	return filepath.Join(dir, ".esshconfig.lua")
+}
+
+// LoadingConfigFiles are the config files that are being loaded. They are used to detect recursive includes.
+var LoadingConfigFiles []string
+
+// loadConfigFiles loads the Lua config file and the YAML or TOML config files that have the same name.
+func loadConfigFiles(L *lua.LState, path string) error {
+	for _, file := range configFiles(path) {
+		if err := loadConfigFile(L, file); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func loadConfigFile(L *lua.LState, file string) error {
+	if debugFlag {
+		fmt.Printf("[essh debug] loading config file: %s\n", file)
+	}
+
+	LoadingConfigFiles = append(LoadingConfigFiles, file)
+	defer func() {
+		LoadingConfigFiles = LoadingConfigFiles[:len(LoadingConfigFiles)-1]
+	}()
+
+	if isDataConfigFile(file) {
+		if err := loadDataConfigFile(L, file); err != nil {
+			return err
+		}
+	} else {
+		if err := L.DoFile(file); err != nil {
+			return err
+		}
+	}
+
+	if debugFlag {
+		fmt.Printf("[essh debug] loaded config file: %s\n", file)
+	}
+
+	LoadedConfigFiles = append(LoadedConfigFiles, file)
+
+	return nil
+}
+
+// loadDataConfigFile registers the hosts, tasks, drivers, groups and tunnels that are defined in a YAML or TOML file.
+// The resources are registered in the same way as the Lua functions like host and task.
+func loadDataConfigFile(L *lua.LState, path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{}
+	if filepath.Ext(path) == ".toml" {
+		_, err = toml.Decode(string(b), &data)
+	} else {
+		err = yaml.Unmarshal(b, &data)
+	}
+	if err != nil {
+		return fmt.Errorf("couldn't parse '%s': %v", path, err)
+	}
+
+	config, _ := toLTable(toLValue(L, data))
+
+	CurrentDataConfigFile = path
+	defer func() {
+		CurrentDataConfigFile = ""
+	}()
+
+	// run in a protected call to get the errors that are raised by the registration functions.
+	return L.CallByParam(lua.P{
+		Fn: L.NewFunction(func(L *lua.LState) int {
+			setupDataConfig(L, config)
+			return 0
+		}),
+		NRet:    0,
+		Protect: true,
+	})
+}
+
+func setupDataConfig(L *lua.LState, config *lua.LTable) {
+	config.ForEach(func(k, v lua.LValue) {
+		key, _ := toString(k)
+		switch key {
+		case "hosts", "tasks", "drivers", "tunnels":
+			setupDataConfigResources(L, key, v)
+		case "groups":
+			setupDataConfigGroups(L, v)
+		default:
+			dataConfigError(L, key, "", v)
+		}
+	})
+}
+
+func setupDataConfigResources(L *lua.LState, key string, value lua.LValue) {
+	resources, ok := toLTable(value)
+	if !ok {
+		dataConfigError(L, key, "a table", value)
+		return
+	}
+
+	resources.ForEach(func(k, v lua.LValue) {
+		name, _ := toString(k)
+		tb, ok := toLTable(v)
+		if !ok {
+			addConfigError(L, &ConfigError{Resource: strings.TrimSuffix(key, "s"), Name: name, Message: fmt.Sprintf("config must be a table but got %s.", describeLValue(v))})
+			return
+		}
+
+		switch key {
+		case "hosts":
+			toSSHConfigStrings(tb)
+			setupHost(L, registerHost(L, name), tb)
+		case "tasks":
+			setupTask(L, registerTask(L, name), tb)
+		case "drivers":
+			setupDriver(L, registerDriver(L, name), tb)
+		case "tunnels":
+			setupTunnel(L, registerTunnel(L, name), tb)
+		}
+	})
+}
+
+func setupDataConfigGroups(L *lua.LState, value lua.LValue) {
+	groups, ok := toLTable(value)
+	if _, isSlice := toSlice(value); !ok || !isSlice {
+		dataConfigError(L, "groups", "an array of tables", value)
+		return
+	}
+
+	for i := 1; i <= groups.MaxN(); i++ {
+		tb, ok := toLTable(groups.RawGetInt(i))
+		if !ok {
+			dataConfigError(L, "groups", "an array of tables", groups.RawGetInt(i))
+			continue
+		}
+
+		// the group's default values are also used for the hosts.
+		toSSHConfigStrings(tb)
+		if hosts, ok := toLTable(tb.RawGetString("hosts")); ok {
+			hosts.ForEach(func(_, v lua.LValue) {
+				if hostTb, ok := toLTable(v); ok {
+					toSSHConfigStrings(hostTb)
+				}
+			})
+		}
+
+		setupGroup(L, registerGroup(L), tb)
+	}
+}
+
+// toSSHConfigStrings converts the ssh_config values that YAML and TOML decode as numbers or booleans (ex. "Port: 22", "ForwardAgent: yes") to strings.
+func toSSHConfigStrings(tb *lua.LTable) {
+	tb.ForEach(func(k, v lua.LValue) {
+		key, _ := toString(k)
+		if key == "" || !unicode.IsUpper([]rune(key)[0]) {
+			return
+		}
+
+		switch value := v.(type) {
+		case lua.LNumber:
+			tb.RawSetString(key, lua.LString(value.String()))
+		case lua.LBool:
+			if value {
+				tb.RawSetString(key, lua.LString("yes"))
+			} else {
+				tb.RawSetString(key, lua.LString("no"))
+			}
+		}
+	})
+}
+
+func dataConfigError(L *lua.LState, key string, expected string, value lua.LValue) {
+	addConfigError(L, &ConfigError{
+		Resource: "config",
+		Field:    key,
+		Expected: expected,
+		Actual:   value,
+	})
+}
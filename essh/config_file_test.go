@@ -0,0 +1,354 @@
+package essh
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestFiles writes the files under the directory. The keys are relative paths.
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFindWorkingDirConfigFile(t *testing.T) {
+	cases := []struct {
+		name     string
+		files    []string
+		dir      string
+		expected string
+	}{
+		{name: "same directory", files: []string{"project/.esshconfig.lua"}, dir: "project", expected: "project/.esshconfig.lua"},
+		{name: "parent directory", files: []string{"project/.esshconfig.lua"}, dir: "project/a/b", expected: "project/.esshconfig.lua"},
+		{name: "nearest directory", files: []string{"project/.esshconfig.lua", "project/a/.esshconfig.lua"}, dir: "project/a/b", expected: "project/a/.esshconfig.lua"},
+		{name: "old file name", files: []string{"project/esshconfig.lua", "project/.esshconfig.lua"}, dir: "project/a", expected: "project/esshconfig.lua"},
+		{name: "data config file only", files: []string{"project/.esshconfig.yaml"}, dir: "project/a", expected: "project/.esshconfig.lua"},
+		{name: "not found", files: []string{"other/.esshconfig.lua"}, dir: "project/a", expected: "project/a/.esshconfig.lua"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "essh-config")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+
+			files := map[string]string{}
+			for _, file := range c.files {
+				files[file] = ""
+			}
+			writeTestFiles(t, root, files)
+			if err := os.MkdirAll(filepath.Join(root, c.dir), 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			if found := findWorkingDirConfigFile(filepath.Join(root, c.dir)); found != filepath.Join(root, c.expected) {
+				t.Errorf("expected %s but got %s", filepath.Join(root, c.expected), found)
+			}
+		})
+	}
+}
+
+func TestResolveScriptFile(t *testing.T) {
+	root, err := ioutil.TempDir("", "essh-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	writeTestFiles(t, root, map[string]string{
+		".esshconfig.lua": `
+essh.include("sub/tasks.lua")
+task "root" { script_file = "scripts/root.sh" }
+task "abs" { script_file = "/opt/abs.sh" }
+task "url" { script_file = "https://example.com/run.sh" }
+`,
+		"sub/tasks.lua": `
+sub_task = task "sub" { script_file = "scripts/sub.sh" }
+`,
+		"sub/.esshconfig.yaml": `
+tasks:
+  data:
+    script_file: scripts/data.sh
+`,
+	})
+
+	L := newTestLState()
+	defer L.Close()
+	if err := loadConfigFiles(L, filepath.Join(root, ".esshconfig.lua")); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadConfigFiles(L, filepath.Join(root, "sub", ".esshconfig.lua")); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"root": filepath.Join(root, "scripts/root.sh"),
+		"sub":  filepath.Join(root, "sub/scripts/sub.sh"),
+		"data": filepath.Join(root, "sub/scripts/data.sh"),
+		"abs":  "/opt/abs.sh",
+		"url":  "https://example.com/run.sh",
+	}
+	for name, expected := range cases {
+		if task := Tasks[name]; task == nil || task.File != expected {
+			t.Errorf("task '%s': expected %s but got %v", name, expected, task)
+		}
+	}
+
+	// the field that is updated after loading is resolved by the task's source.
+	task := Tasks["sub"]
+	if err := L.DoString(`sub_task.script_file = "other.sh"`); err != nil {
+		t.Fatal(err)
+	}
+	if expected := filepath.Join(root, "sub/other.sh"); task.File != expected {
+		t.Errorf("expected %s but got %s", expected, task.File)
+	}
+}
+
+func TestResolveScriptFileInUserConfig(t *testing.T) {
+	root, err := ioutil.TempDir("", "essh-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	writeTestFiles(t, root, map[string]string{
+		".essh/config.lua": `
+essh.include("lib/tasks.lua")
+task "user" { script_file = "scripts/user.sh" }
+`,
+		".essh/config_override.lua": `
+task "override" { script_file = "scripts/override.sh" }
+`,
+		".essh/lib/tasks.lua": `
+task "included" { script_file = "scripts/included.sh" }
+`,
+	})
+
+	userConfigFile, userOverrideConfigFile := UserConfigFile, UserOverrideConfigFile
+	UserConfigFile = filepath.Join(root, ".essh", "config.lua")
+	UserOverrideConfigFile = filepath.Join(root, ".essh", "config_override.lua")
+	defer func() {
+		UserConfigFile, UserOverrideConfigFile = userConfigFile, userOverrideConfigFile
+	}()
+
+	L := newTestLState()
+	defer L.Close()
+	for _, path := range []string{UserConfigFile, UserOverrideConfigFile} {
+		if err := loadConfigFiles(L, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the per-user config files resolve the paths from the current directory, but the included files don't.
+	cases := map[string]string{
+		"user":     "scripts/user.sh",
+		"override": "scripts/override.sh",
+		"included": filepath.Join(root, ".essh/lib/scripts/included.sh"),
+	}
+	for name, expected := range cases {
+		if task := Tasks[name]; task == nil || task.File != expected {
+			t.Errorf("task '%s': expected %s but got %v", name, expected, task)
+		}
+	}
+}
+
+func TestLoadDataConfigFile(t *testing.T) {
+	cases := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{
+			name: "yaml",
+			file: "esshconfig.yaml",
+			content: `
+hosts:
+  web01:
+    HostName: 192.168.0.11
+    Port: 2222
+    ForwardAgent: yes
+    description: web01 server
+    tags: [web]
+    props:
+      role: app
+
+tasks:
+  uptime:
+    backend: remote
+    targets: [web]
+    script:
+      - uptime
+
+groups:
+  - hidden: true
+    hosts:
+      gateway:
+        HostName: 192.168.0.1
+`,
+		},
+		{
+			name: "toml",
+			file: "esshconfig.toml",
+			content: `
+[hosts.web01]
+HostName = "192.168.0.11"
+Port = 2222
+ForwardAgent = true
+description = "web01 server"
+tags = ["web"]
+props = { role = "app" }
+
+[tasks.uptime]
+backend = "remote"
+targets = ["web"]
+script = ["uptime"]
+
+[[groups]]
+hidden = true
+[groups.hosts.gateway]
+HostName = "192.168.0.1"
+`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "essh-config")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+
+			writeTestFiles(t, root, map[string]string{c.file: c.content})
+
+			L := newTestLState()
+			defer L.Close()
+
+			path := filepath.Join(root, c.file)
+			if err := loadDataConfigFile(L, path); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(CollectedConfigErrors) > 0 {
+				t.Fatalf("unexpected config errors: %v", CollectedConfigErrors)
+			}
+
+			web01 := Hosts["web01"]
+			if web01 == nil {
+				t.Fatalf("host 'web01' is not defined")
+			}
+			if web01.SSHConfig["HostName"] != "192.168.0.11" || web01.SSHConfig["Port"] != "2222" || web01.SSHConfig["ForwardAgent"] != "yes" {
+				t.Errorf("unexpected ssh_config: %v", web01.SSHConfig)
+			}
+			if web01.Description != "web01 server" || len(web01.Tags) != 1 || web01.Tags[0] != "web" || web01.Props["role"] != "app" {
+				t.Errorf("unexpected host: %+v", web01)
+			}
+			if web01.Source != path {
+				t.Errorf("expected the source %s but got %s", path, web01.Source)
+			}
+
+			if gateway := Hosts["gateway"]; gateway == nil || !gateway.Hidden {
+				t.Errorf("expected the hidden host 'gateway' but got %+v", gateway)
+			}
+
+			uptime := Tasks["uptime"]
+			if uptime == nil {
+				t.Fatalf("task 'uptime' is not defined")
+			}
+			if uptime.Backend != TASK_BACKEND_REMOTE || len(uptime.Script) != 1 || uptime.Script[0]["code"] != "uptime" {
+				t.Errorf("unexpected task: %+v", uptime)
+			}
+		})
+	}
+}
+
+func TestLoadDataConfigFileErrors(t *testing.T) {
+	cases := []struct {
+		name    string
+		file    string
+		content string
+		errors  []string
+		err     string
+	}{
+		{
+			name:    "invalid syntax",
+			file:    "esshconfig.yaml",
+			content: "hosts: [",
+			err:     "couldn't parse",
+		},
+		{
+			name: "invalid fields",
+			file: "esshconfig.yaml",
+			content: `
+hosts:
+  web01: 1
+unknown: 1
+`,
+			errors: []string{
+				"host 'web01': config must be a table but got number 1.",
+				"config: unsupported field 'unknown'.",
+			},
+		},
+		{
+			name: "invalid groups",
+			file: "esshconfig.toml",
+			content: `
+[groups]
+hidden = true
+`,
+			errors: []string{"config: 'groups' must be an array of tables but got table."},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "essh-config")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+
+			writeTestFiles(t, root, map[string]string{c.file: c.content})
+
+			L := newTestLState()
+			defer L.Close()
+
+			err = loadDataConfigFile(L, filepath.Join(root, c.file))
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Errorf("expected error %q but got %v", c.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(CollectedConfigErrors) != len(c.errors) {
+				t.Fatalf("expected %d errors but got %v", len(c.errors), CollectedConfigErrors)
+			}
+			for _, expected := range c.errors {
+				found := false
+				for _, actual := range CollectedConfigErrors {
+					if strings.HasSuffix(actual.Error(), expected) {
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("expected error %q in %v", expected, CollectedConfigErrors)
+				}
+			}
+		})
+	}
+}
@@ -16,6 +16,7 @@ type Driver struct {
 	Registry *Registry
 	Group    *Group
 	LValues  map[string]lua.LValue
+	Source   string
 	Parent   *Driver
 	Child    *Driver
 }
@@ -116,6 +117,9 @@ export ESSH_DEBUG="{{if .Debug}}1{{end}}"
 {{range $key, $value := .Task.Props -}}
 export ESSH_TASK_PROPS_{{$key | ToUpper | EnvKeyEscape}}={{$value | ShellEscape }}
 {{end -}}
+{{range $key, $value := .Task.ParamValues -}}
+export ESSH_TASK_PARAMS_{{$key | ToUpper | EnvKeyEscape}}={{$value | ShellEscape }}
+{{end -}}
 {{range $index, $value := .Task.Args -}}
 export ESSH_TASK_ARGS_{{Add $index 1 }}={{$value | ShellEscape }}
 {{end -}}
@@ -133,6 +137,12 @@ export ESSH_HOST_PROPS_{{$key | ToUpper | EnvKeyEscape}}={{$value | ShellEscape
 {{range $i, $value := .Host.Tags -}}
 export ESSH_HOST_TAGS_{{$value | ToUpper | EnvKeyEscape}}=1
 {{end -}}
+{{range $key, $value := .Host.Env -}}
+export {{$key}}={{$value | ShellEscape }}
+{{end -}}
+{{end -}}
+{{range $key, $value := .Task.Env -}}
+export {{$key}}={{$value | ShellEscape }}
 {{end -}}
 {{end}}
 `
@@ -189,17 +199,20 @@ func esshDriver(L *lua.LState) int {
 		return 1
 	}
 
-	panic("driver requires 1 or 2 arguments")
+	argumentsError(L, "driver", "requires 1 or 2 arguments.")
+
+	return 0
 }
 
 func registerDriver(L *lua.LState, name string) *Driver {
 	if debugFlag {
-		fmt.Printf("[essh debug] register driver: %s\n", name)
+		fmt.Printf("[essh debug] register driver: %s (%s)\n", name, luaSourcePosition(L))
 	}
 
 	d := NewDriver()
 	d.Name = name
 	d.Registry = CurrentRegistry
+	d.Source = luaSourcePosition(L)
 
 	if driver := Drivers[d.Name]; driver != nil {
 		// detect same name driver
@@ -250,7 +263,7 @@ func updateDriver(L *lua.LState, driver *Driver, key string, value lua.LValue) {
 				return engineStr, nil
 			}
 		} else {
-			L.RaiseError("driver 'engine' have to be a function or string.")
+			fieldError(L, "driver", driver.Name, driver.Source, key, "a function or string", value)
 		}
 	}
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/kardianos/osext"
 	"github.com/kohkimakimoto/essh/support/color"
 	"github.com/kohkimakimoto/essh/support/helper"
+	"github.com/kohkimakimoto/essh/support/vault"
 	"github.com/yuin/gopher-lua"
 	"io"
 	"io/ioutil"
@@ -20,6 +21,7 @@ import (
 	"sync"
 	"syscall"
 	"text/template"
+	"time"
 )
 
 // system configurations.
@@ -49,6 +51,19 @@ var (
 	tasksFlag   bool
 	genFlag     bool
 	globalFlag  bool
+	explainFlag bool
+
+	encryptValueFlag bool
+	decryptFileFlag  bool
+
+	historyFlag    bool
+	rerunVar       string
+	rerunFailedVar string
+
+	scanHostKeysFlag bool
+	lintFlag         bool
+	testFlag         bool
+	testFormatVar    string
 
 	zshCompletionModeFlag       bool
 	zshCompletionFlag           bool
@@ -66,6 +81,11 @@ var (
 
 	aliasesFlag     bool
 	execFlag        bool
+	shellFlag       bool
+	pickFlag        bool
+	tunnelsFlag     bool
+	tunnelVar       string
+	stopTunnelVar   string
 	fileFlag        bool
 	prefixFlag      bool
 	parallelFlag    bool
@@ -81,12 +101,28 @@ var (
 	backendVar      string
 	prefixStringVar string
 	driverVar       string
+	envVar          []string
+	watchVar        string
 )
 
 const (
 	ExitErr = 1
 )
 
+// esshOptions are the names of the options that Essh consumes from the command line arguments.
+// Task params can't use these names, because Essh parses the options even if they are after the task name.
+var esshOptions = []string{
+	"aliases", "all", "backend", "bash-completion", "bash-completion-hosts",
+	"bash-completion-namespaces", "bash-completion-tags", "bash-completion-tasks", "color", "config",
+	"debug", "decrypt-file", "driver", "encrypt-value", "exec", "explain", "filter", "gen",
+	"global", "help", "history", "hosts", "lint", "no-color", "parallel", "pick", "prefix",
+	"prefix-string", "print", "privileged", "pty", "quiet", "rerun", "rerun-failed", "scan-host-keys",
+	"script-file", "select", "shell", "ssh-config", "stop-tunnel", "tags", "target", "tasks", "test",
+	"test-format", "tunnel", "tunnels", "user", "version", "watch", "working-dir", "zsh-completion",
+	"zsh-completion-hosts", "zsh-completion-namespaces", "zsh-completion-tags",
+	"zsh-completion-tasks",
+}
+
 func initResources() {
 	// Flags
 	helpFlag = false
@@ -101,6 +137,12 @@ func initResources() {
 	tasksFlag = false
 	genFlag = false
 	globalFlag = false
+	explainFlag = false
+	encryptValueFlag = false
+	decryptFileFlag = false
+	historyFlag = false
+	rerunVar = ""
+	rerunFailedVar = ""
 	zshCompletionModeFlag = false
 	zshCompletionFlag = false
 	zshCompletionHostsFlag = false
@@ -115,6 +157,15 @@ func initResources() {
 	bashCompletionNamespacesFlag = false
 	aliasesFlag = false
 	execFlag = false
+	shellFlag = false
+	pickFlag = false
+	tunnelsFlag = false
+	tunnelVar = ""
+	stopTunnelVar = ""
+	scanHostKeysFlag = false
+	lintFlag = false
+	testFlag = false
+	testFormatVar = TestFormatTAP
 	fileFlag = false
 	prefixFlag = false
 	parallelFlag = false
@@ -130,6 +181,8 @@ func initResources() {
 	backendVar = ""
 	prefixStringVar = ""
 	driverVar = ""
+	envVar = []string{}
+	watchVar = ""
 
 	// Registry
 	CurrentRegistry = nil
@@ -140,6 +193,31 @@ func initResources() {
 	Hosts = map[string]*Host{}
 	Tasks = map[string]*Task{}
 	Drivers = map[string]*Driver{}
+	Tunnels = map[string]*Tunnel{}
+
+	// Secrets
+	Secrets = NewSecretStore()
+
+	// Config errors
+	LoadingConfig = false
+	CollectedConfigErrors = ConfigErrors{}
+
+	// Includes
+	CurrentNamespace = nil
+	LoadingConfigFiles = []string{}
+
+	// Audit
+	AuditLogFile = ""
+	LoadedConfigFiles = []string{}
+
+	// History
+	HistoryFile = filepath.Join(UserDataDir, "history")
+
+	// Known hosts
+	KnownHostsEnabled = false
+	KnownHostsFile = ""
+	KnownHostsCheck = false
+	checkedHostKeys = map[string]bool{}
 
 	// set built-in drivers
 	driver := NewDriver()
@@ -237,6 +315,32 @@ func Run(osArgs []string) (exitStatus int) {
 			genFlag = true
 		} else if arg == "--global" {
 			globalFlag = true
+		} else if arg == "--explain" {
+			explainFlag = true
+		} else if arg == "--encrypt-value" {
+			encryptValueFlag = true
+		} else if arg == "--decrypt-file" {
+			decryptFileFlag = true
+		} else if arg == "--history" {
+			historyFlag = true
+		} else if arg == "--rerun" {
+			if len(osArgs) < 2 {
+				printError("--rerun requires an argument.")
+				return ExitErr
+			}
+			rerunVar = osArgs[1]
+			osArgs = osArgs[1:]
+		} else if strings.HasPrefix(arg, "--rerun=") {
+			rerunVar = strings.Split(arg, "=")[1]
+		} else if arg == "--rerun-failed" {
+			if len(osArgs) < 2 {
+				printError("--rerun-failed requires an argument.")
+				return ExitErr
+			}
+			rerunFailedVar = osArgs[1]
+			osArgs = osArgs[1:]
+		} else if strings.HasPrefix(arg, "--rerun-failed=") {
+			rerunFailedVar = strings.Split(arg, "=")[1]
 		} else if arg == "--zsh-completion" {
 			zshCompletionFlag = true
 			zshCompletionModeFlag = true
@@ -249,6 +353,9 @@ func Run(osArgs []string) (exitStatus int) {
 		} else if arg == "--zsh-completion-tasks" {
 			zshCompletionTasksFlag = true
 			zshCompletionModeFlag = true
+		} else if arg == "--zsh-completion-namespaces" {
+			zshCompletionNamespacesFlag = true
+			zshCompletionModeFlag = true
 		} else if arg == "--bash-completion" {
 			bashCompletionFlag = true
 			bashCompletionModeFlag = true
@@ -261,6 +368,9 @@ func Run(osArgs []string) (exitStatus int) {
 		} else if arg == "--bash-completion-tasks" {
 			bashCompletionTasksFlag = true
 			bashCompletionModeFlag = true
+		} else if arg == "--bash-completion-namespaces" {
+			bashCompletionNamespacesFlag = true
+			bashCompletionModeFlag = true
 		} else if arg == "--aliases" {
 			aliasesFlag = true
 		} else if arg == "--working-dir" {
@@ -283,6 +393,45 @@ func Run(osArgs []string) (exitStatus int) {
 			configVar = strings.Split(arg, "=")[1]
 		} else if arg == "--exec" {
 			execFlag = true
+		} else if arg == "--shell" {
+			shellFlag = true
+		} else if arg == "--pick" {
+			pickFlag = true
+		} else if arg == "--tunnels" {
+			tunnelsFlag = true
+		} else if arg == "--tunnel" {
+			if len(osArgs) < 2 {
+				printError("--tunnel requires an argument.")
+				return ExitErr
+			}
+			tunnelVar = osArgs[1]
+			osArgs = osArgs[1:]
+		} else if strings.HasPrefix(arg, "--tunnel=") {
+			tunnelVar = strings.Split(arg, "=")[1]
+		} else if arg == "--stop-tunnel" {
+			if len(osArgs) < 2 {
+				printError("--stop-tunnel requires an argument.")
+				return ExitErr
+			}
+			stopTunnelVar = osArgs[1]
+			osArgs = osArgs[1:]
+		} else if strings.HasPrefix(arg, "--stop-tunnel=") {
+			stopTunnelVar = strings.Split(arg, "=")[1]
+		} else if arg == "--lint" {
+			lintFlag = true
+		} else if arg == "--test" {
+			testFlag = true
+		} else if arg == "--test-format" {
+			if len(osArgs) < 2 {
+				printError("--test-format requires an argument.")
+				return ExitErr
+			}
+			testFormatVar = osArgs[1]
+			osArgs = osArgs[1:]
+		} else if strings.HasPrefix(arg, "--test-format=") {
+			testFormatVar = strings.Split(arg, "=")[1]
+		} else if arg == "--scan-host-keys" {
+			scanHostKeysFlag = true
 		} else if arg == "--privileged" {
 			privilegedFlag = true
 		} else if arg == "--user" {
@@ -343,6 +492,15 @@ func Run(osArgs []string) (exitStatus int) {
 			osArgs = osArgs[1:]
 		} else if strings.HasPrefix(arg, "--backend=") {
 			backendVar = strings.Split(arg, "=")[1]
+		} else if arg == "--watch" {
+			if len(osArgs) < 2 {
+				printError("--watch requires an argument.")
+				return ExitErr
+			}
+			watchVar = osArgs[1]
+			osArgs = osArgs[1:]
+		} else if strings.HasPrefix(arg, "--watch=") {
+			watchVar = strings.Split(arg, "=")[1]
 		} else if arg == "--script-file" {
 			fileFlag = true
 		} else if arg == "--pty" {
@@ -387,13 +545,10 @@ func Run(osArgs []string) (exitStatus int) {
 	}
 
 	WorkingDir = wd
-	WorkingDataDir = filepath.Join(wd, ".essh")
-	WorkingDirConfigFile = filepath.Join(wd, ".esshconfig.lua")
 
-	// This is for Backward Compatibility
-	if _, err := os.Stat(filepath.Join(wd, "esshconfig.lua")); err == nil {
-		WorkingDirConfigFile = filepath.Join(wd, "esshconfig.lua")
-	}
+	// find the config file in the working directory or the nearest parent directory.
+	WorkingDirConfigFile = findWorkingDirConfigFile(wd)
+	WorkingDataDir = filepath.Join(filepath.Dir(WorkingDirConfigFile), ".essh")
 
 	// use config file path from environment variable if it set.
 	if configVar == "" && os.Getenv("ESSH_CONFIG") != "" {
@@ -423,7 +578,7 @@ func Run(osArgs []string) (exitStatus int) {
 
 	WorkingDirOverrideConfigFile = filepath.Join(workingDirConfigFileDir, workingDirConfigFileName+"_override"+workingDirConfigFileBasenameExtension)
 
-	if helpFlag {
+	if helpFlag && len(args) == 0 {
 		printHelp()
 		return
 	}
@@ -466,6 +621,56 @@ func Run(osArgs []string) (exitStatus int) {
 		return
 	}
 
+	if encryptValueFlag {
+		var value []byte
+		if len(args) == 0 {
+			// read the value from stdin.
+			b, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				printError(err)
+				return ExitErr
+			}
+			// the newline of the input like "echo secret | essh --encrypt-value" is not a part of the value.
+			value = []byte(TrimNewline(string(b)))
+		} else if len(args) == 1 {
+			value = []byte(args[0])
+		} else {
+			printError("--encrypt-value requires 1 parameter or stdin.")
+			return ExitErr
+		}
+
+		key, err := LoadVaultKey("", "")
+		if err != nil {
+			printError(err)
+			return ExitErr
+		}
+
+		encrypted, err := vault.Encrypt(key, value)
+		if err != nil {
+			printError(err)
+			return ExitErr
+		}
+
+		fmt.Println(encrypted)
+		return
+	}
+
+	if decryptFileFlag {
+		if len(args) != 1 {
+			printError("--decrypt-file requires 1 parameter.")
+			return ExitErr
+		}
+
+		content, err := DecryptFile(args[0])
+		if err != nil {
+			printError(err)
+			return ExitErr
+		}
+
+		fmt.Print(content)
+		return
+	}
+
 	// extend lua package path.
 	libdir := filepath.Join(UserDataDir, "lib")
 	libdir2 := filepath.Join(WorkingDataDir, "lib")
@@ -521,44 +726,27 @@ func Run(osArgs []string) (exitStatus int) {
 
 	CurrentRegistry = GlobalRegistry
 
-	if _, err := os.Stat(WorkingDirConfigFile); err == nil && !globalFlag {
+	// the problems of the resource definitions are reported together after loading all config files.
+	LoadingConfig = true
+
+	if len(configFiles(WorkingDirConfigFile)) > 0 && !globalFlag {
 		// has working directroy config file
 
 		// change context to working dir context
 		CurrentRegistry = LocalRegistry
 
 		// load working directory config
-		if _, err := os.Stat(WorkingDirConfigFile); err == nil {
-			if debugFlag {
-				fmt.Printf("[essh debug] loading config file: %s\n", WorkingDirConfigFile)
-			}
-
-			if err := L.DoFile(WorkingDirConfigFile); err != nil {
-				printError(err)
-				return ExitErr
-			}
-
-			if debugFlag {
-				fmt.Printf("[essh debug] loaded config file: %s\n", WorkingDirConfigFile)
-			}
+		if err := loadConfigFiles(L, WorkingDirConfigFile); err != nil {
+			printError(configLoadError(err))
+			return ExitErr
 		}
 	} else {
 		// does not have working directory config file
 
 		// load per-user configuration file.
-		if _, err := os.Stat(UserConfigFile); err == nil {
-			if debugFlag {
-				fmt.Printf("[essh debug] loading config file: %s\n", UserConfigFile)
-			}
-
-			if err := L.DoFile(UserConfigFile); err != nil {
-				printError(err)
-				return ExitErr
-			}
-
-			if debugFlag {
-				fmt.Printf("[essh debug] loaded config file: %s\n", UserConfigFile)
-			}
+		if err := loadConfigFiles(L, UserConfigFile); err != nil {
+			printError(configLoadError(err))
+			return ExitErr
 		}
 	}
 
@@ -566,38 +754,43 @@ func Run(osArgs []string) (exitStatus int) {
 	CurrentRegistry = LocalRegistry
 
 	// load working directory override config
-	if _, err := os.Stat(WorkingDirOverrideConfigFile); err == nil && !globalFlag {
-		if debugFlag {
-			fmt.Printf("[essh debug] loading config file: %s\n", WorkingDirOverrideConfigFile)
-		}
-
-		if err := L.DoFile(WorkingDirOverrideConfigFile); err != nil {
-			printError(err)
+	if !globalFlag {
+		if err := loadConfigFiles(L, WorkingDirOverrideConfigFile); err != nil {
+			printError(configLoadError(err))
 			return ExitErr
 		}
-
-		if debugFlag {
-			fmt.Printf("[essh debug] loaded config file: %s\n", WorkingDirOverrideConfigFile)
-		}
 	}
 
 	// change context to global
 	CurrentRegistry = GlobalRegistry
 
 	// load override global config
-	if _, err := os.Stat(UserOverrideConfigFile); err == nil {
-		if debugFlag {
-			fmt.Printf("[essh debug] loading config file: %s\n", UserOverrideConfigFile)
+	if err := loadConfigFiles(L, UserOverrideConfigFile); err != nil {
+		printError(configLoadError(err))
+		return ExitErr
+	}
+
+	LoadingConfig = false
+
+	// check the config without running anything
+	if lintFlag {
+		problems := lintResources(Tasks, Hosts, Tunnels)
+		for _, problem := range problems {
+			fmt.Println(problem.String())
 		}
 
-		if err := L.DoFile(UserOverrideConfigFile); err != nil {
-			printError(err)
+		if len(problems) > 0 {
+			fmt.Fprint(os.Stderr, color.FgRB("essh error: found %d problems in the config.\n", len(problems)))
 			return ExitErr
 		}
 
-		if debugFlag {
-			fmt.Printf("[essh debug] loaded config file: %s\n", UserOverrideConfigFile)
-		}
+		fmt.Println("no problems found.")
+		return
+	}
+
+	if len(CollectedConfigErrors) > 0 {
+		printError(CollectedConfigErrors)
+		return ExitErr
 	}
 
 	// validate config
@@ -606,6 +799,49 @@ func Run(osArgs []string) (exitStatus int) {
 		return ExitErr
 	}
 
+	// sensitive values are redacted in the outputs.
+	Secrets.AddSensitiveValues(Hosts, Tasks)
+
+	// set up the audit log.
+	if auditLog := lessh.RawGetString("audit_log"); auditLog != lua.LNil {
+		if auditLogBool, ok := toBool(auditLog); ok {
+			if auditLogBool {
+				AuditLogFile = filepath.Join(UserDataDir, "audit.log")
+			}
+		} else if auditLogStr, ok := toString(auditLog); ok {
+			AuditLogFile = auditLogStr
+		} else {
+			printError(fmt.Errorf("invalid value %v in the 'audit_log'", auditLog))
+			return ExitErr
+		}
+	}
+
+	if os.Getenv("ESSH_AUDIT_LOG") != "" {
+		AuditLogFile = os.Getenv("ESSH_AUDIT_LOG")
+	}
+
+	// set up the known_hosts management.
+	if knownHosts := lessh.RawGetString("known_hosts"); knownHosts != lua.LNil {
+		if knownHostsBool, ok := toBool(knownHosts); ok {
+			KnownHostsEnabled = knownHostsBool
+		} else if knownHostsStr, ok := toString(knownHosts); ok {
+			KnownHostsEnabled = true
+			KnownHostsFile = knownHostsStr
+		} else {
+			printError(fmt.Errorf("invalid value %v in the 'known_hosts'", knownHosts))
+			return ExitErr
+		}
+	}
+
+	if checkKeys := lessh.RawGetString("check_host_keys"); checkKeys != lua.LNil {
+		if checkKeysBool, ok := toBool(checkKeys); ok {
+			KnownHostsCheck = checkKeysBool
+		} else {
+			printError(fmt.Errorf("invalid value %v in the 'check_host_keys'", checkKeys))
+			return ExitErr
+		}
+	}
+
 	// show hosts for zsh completion
 	if zshCompletionHostsFlag {
 		for _, host := range NewHostQuery().GetHostsOrderByName() {
@@ -648,6 +884,13 @@ func Run(osArgs []string) (exitStatus int) {
 		return
 	}
 
+	if zshCompletionNamespacesFlag || bashCompletionNamespacesFlag {
+		for _, namespace := range GetNamespaces(Tasks) {
+			fmt.Printf("%s\n", ColonEscape(namespace))
+		}
+		return
+	}
+
 	if zshCompletionTagsFlag || bashCompletionTagsFlag {
 		for _, tag := range GetTags(Hosts) {
 			fmt.Printf("%s\n", ColonEscape(tag))
@@ -655,6 +898,32 @@ func Run(osArgs []string) (exitStatus int) {
 		return
 	}
 
+	// print help of the task
+	if helpFlag {
+		if task := GetEnabledTask(args[0]); task != nil {
+			printTaskHelp(task)
+		} else {
+			printHelp()
+		}
+
+		return
+	}
+
+	// explain layers of the resource
+	if explainFlag {
+		if len(args) != 2 {
+			printError("--explain requires 2 parameters like '--explain host <name>'.")
+			return ExitErr
+		}
+
+		if err := explainResource(os.Stdout, args[0], args[1]); err != nil {
+			printError(err)
+			return ExitErr
+		}
+
+		return
+	}
+
 	// only print hosts list
 	if hostsFlag {
 		if len(selectVar) == 0 && len(filterVar) > 0 {
@@ -683,11 +952,11 @@ func Run(osArgs []string) (exitStatus int) {
 			}
 
 			// print generated config
-			fmt.Println(string(content))
+			fmt.Println(Secrets.Mask(string(content)))
 		} else {
 			tb := helper.NewPlainTable(os.Stdout)
 			if !quietFlag {
-				tb.SetHeader([]string{"NAME", "DESCRIPTION", "TAGS", "HIDDEN"})
+				tb.SetHeader([]string{"NAME", "DESCRIPTION", "TAGS", "HIDDEN", "SOURCE"})
 			}
 
 			for _, host := range filteredHosts {
@@ -698,7 +967,7 @@ func Run(osArgs []string) (exitStatus int) {
 					if host.Hidden {
 						hidden = "true"
 					}
-					tb.Append([]string{host.Name, host.Description, strings.Join(host.Tags, ","), hidden})
+					tb.Append([]string{host.Name, host.Description, strings.Join(host.Tags, ","), hidden, host.Source})
 				}
 			}
 
@@ -726,7 +995,7 @@ func Run(osArgs []string) (exitStatus int) {
 	if tasksFlag {
 		tb := helper.NewPlainTable(os.Stdout)
 		if !quietFlag {
-			tb.SetHeader([]string{"NAME", "DESCRIPTION", "HIDDEN"})
+			tb.SetHeader([]string{"NAME", "DESCRIPTION", "PARAMS", "HIDDEN", "SOURCE"})
 		}
 		for _, t := range NewTaskQuery().GetTasksOrderByName() {
 			hidden := t.Hidden
@@ -734,7 +1003,7 @@ func Run(osArgs []string) (exitStatus int) {
 				if quietFlag {
 					tb.Append([]string{t.PublicName()})
 				} else {
-					tb.Append([]string{t.PublicName(), t.Description, fmt.Sprintf("%v", t.Hidden)})
+					tb.Append([]string{t.PublicName(), t.Description, t.ParamsUsage(), fmt.Sprintf("%v", t.Hidden), t.Source})
 				}
 			}
 		}
@@ -743,6 +1012,56 @@ func Run(osArgs []string) (exitStatus int) {
 		return
 	}
 
+	// only print history of the task runs
+	if historyFlag {
+		records, err := LoadHistory()
+		if err != nil {
+			printError(err)
+			return ExitErr
+		}
+
+		tb := helper.NewPlainTable(os.Stdout)
+		if !quietFlag {
+			tb.SetHeader([]string{"ID", "TASK", "ARGS", "HOSTS", "STATUS", "START", "DURATION"})
+		}
+		filtered := []*HistoryRecord{}
+		for _, record := range records {
+			if record.WorkingDir == WorkingDir || allFlag {
+				filtered = append(filtered, record)
+			}
+		}
+		if len(filtered) > HistoryMaxRecords {
+			filtered = filtered[len(filtered)-HistoryMaxRecords:]
+		}
+
+		for _, record := range filtered {
+			if quietFlag {
+				tb.Append([]string{fmt.Sprintf("%d", record.ID)})
+			} else {
+				name := record.Task
+				if record.Command != "" {
+					name = record.Task + " " + record.Command
+				}
+				hosts := strings.Join(record.Hosts, ",")
+				if len(record.FailedHosts) > 0 && len(record.Hosts) > 0 {
+					hosts += " (failed: " + strings.Join(record.FailedHosts, ",") + ")"
+				}
+				tb.Append([]string{
+					fmt.Sprintf("%d", record.ID),
+					name,
+					Secrets.Mask(strings.Join(record.Args, " ")),
+					hosts,
+					record.Status,
+					record.StartTime.Local().Format("2006-01-02 15:04:05"),
+					record.Duration().Round(time.Millisecond).String(),
+				})
+			}
+		}
+		tb.Render()
+
+		return
+	}
+
 	outputConfig, ok := toString(lessh.RawGetString("ssh_config"))
 	if !ok {
 		printError(fmt.Errorf("invalid value %v in the 'ssh_config'", lessh.RawGetString("ssh_config")))
@@ -758,7 +1077,7 @@ func Run(osArgs []string) (exitStatus int) {
 
 	// only print generated config
 	if printFlag {
-		fmt.Println(string(content))
+		fmt.Println(Secrets.Mask(string(content)))
 		return
 	}
 
@@ -767,9 +1086,179 @@ func Run(osArgs []string) (exitStatus int) {
 		return
 	}
 
-	// select running mode and run it.
-	if execFlag {
-		if len(args) == 0 {
+	// run the tests of the config
+	if testFlag {
+		if testFormatVar != TestFormatTAP && testFormatVar != TestFormatJUnit {
+			printError(fmt.Errorf("--test-format must be '%s' or '%s' but got '%s'.", TestFormatTAP, TestFormatJUnit, testFormatVar))
+			return ExitErr
+		}
+
+		paths := args
+		if len(paths) == 0 {
+			paths = []string{WorkingDir}
+		}
+
+		files, err := FindTestFiles(paths)
+		if err != nil {
+			printError(err)
+			return ExitErr
+		}
+
+		suites := runTests(L, outputConfig, files)
+		if testFormatVar == TestFormatJUnit {
+			if err := writeJUnit(os.Stdout, suites); err != nil {
+				printError(err)
+				return ExitErr
+			}
+		} else {
+			writeTAP(os.Stdout, suites)
+		}
+
+		for _, suite := range suites {
+			if suite.Failures() > 0 {
+				return ExitErr
+			}
+		}
+
+		return
+	}
+
+	// list tunnels
+	if tunnelsFlag {
+		tb := helper.NewPlainTable(os.Stdout)
+		if !quietFlag {
+			tb.SetHeader([]string{"NAME", "DESCRIPTION", "HOST", "FORWARD", "STATUS", "SOURCE"})
+		}
+		for _, t := range GetTunnelsOrderByName() {
+			if quietFlag {
+				tb.Append([]string{t.Name})
+			} else {
+				status := "stopped"
+				if pid := t.PID(); pid != 0 {
+					status = fmt.Sprintf("running (pid: %d)", pid)
+				}
+				tb.Append([]string{t.Name, t.Description, t.Host, t.Forward(), status, t.Source})
+			}
+		}
+		tb.Render()
+
+		return
+	}
+
+	// start a tunnel in background
+	if tunnelVar != "" {
+		t := Tunnels[tunnelVar]
+		if t == nil {
+			printError(fmt.Errorf("tunnel '%s' is not defined.", tunnelVar))
+			return ExitErr
+		}
+
+		pid, err := t.Start(outputConfig)
+		if err != nil {
+			printError(err)
+			return ExitErr
+		}
+
+		fmt.Printf("started tunnel '%s' (%s via %s, pid: %d)\n", t.Name, t.Forward(), t.Host, pid)
+		return
+	}
+
+	// stop a tunnel
+	if stopTunnelVar != "" {
+		t := Tunnels[stopTunnelVar]
+		if t == nil {
+			printError(fmt.Errorf("tunnel '%s' is not defined.", stopTunnelVar))
+			return ExitErr
+		}
+
+		if err := t.Stop(); err != nil {
+			printError(err)
+			return ExitErr
+		}
+
+		fmt.Printf("stopped tunnel '%s'\n", t.Name)
+		return
+	}
+
+	// record the host keys
+	if scanHostKeysFlag {
+		if len(targetVar) == 0 {
+			printError("--scan-host-keys must be used with --target option.")
+			return ExitErr
+		}
+
+		hosts := NewHostQuery().AppendSelections(targetVar).AppendFilters(filterVar).GetHostsOrderByName()
+		if len(hosts) == 0 {
+			printError("There are not hosts to scan. you must specify the valid hosts.")
+			return ExitErr
+		}
+
+		if err := scanHostKeys(hosts); err != nil {
+			printError(err)
+			return ExitErr
+		}
+
+		return
+	}
+
+	// rerun the task by the history
+	if rerunVar != "" || rerunFailedVar != "" {
+		task, args, err := rerunTask(rerunVar, rerunFailedVar)
+		if err != nil {
+			printError(err)
+			return ExitErr
+		}
+
+		if err := runTask(outputConfig, task, args, L); err != nil {
+			printError(err)
+			return ExitErr
+		}
+
+		return
+	}
+
+	// pick a host interactively.
+	if !pickFlag && len(args) == 0 && !execFlag && !shellFlag {
+		if pick, ok := toBool(lessh.RawGetString("pick")); ok && pick {
+			pickFlag = true
+		}
+	}
+
+	if pickFlag {
+		host, err := NewHostPicker(NewHostQuery().isVisible().GetHostsOrderByName()).Pick()
+		if err != nil {
+			printError(err)
+			return ExitErr
+		}
+
+		if host == nil {
+			// canceled
+			return ExitErr
+		}
+
+		if execFlag || shellFlag {
+			targetVar = append(targetVar, host.Name)
+		} else {
+			err, ex := runSSH(L, outputConfig, append([]string{host.Name}, args...))
+			if err != nil {
+				printError(err)
+				return ExitErr
+			}
+
+			return ex
+		}
+	}
+
+	// select running mode and run it.
+	if execFlag || shellFlag {
+		// --env is parsed only in exec mode, because "--env" after a task name is passed to the task as a param.
+		envVar, args, err = parseEnvOptions(args)
+		if err != nil {
+			printError(err)
+			return ExitErr
+		}
+
+		if execFlag && len(args) == 0 {
 			printError("exec mode requires 1 parameter at latest.")
 			return ExitErr
 		}
@@ -778,7 +1267,7 @@ func Run(osArgs []string) (exitStatus int) {
 
 		// create temporary task
 		task := NewTask()
-		task.Name = "--exec"
+		task.Name = ExecTaskName
 		task.Pty = ptyFlag
 		task.Parallel = parallelFlag
 		task.Privileged = privilegedFlag
@@ -786,7 +1275,7 @@ func Run(osArgs []string) (exitStatus int) {
 		task.Driver = driverVar
 		if fileFlag {
 			task.File = command
-		} else {
+		} else if command != "" {
 			task.Script = []map[string]string{
 				map[string]string{"code": command},
 			}
@@ -795,6 +1284,15 @@ func Run(osArgs []string) (exitStatus int) {
 			task.Backend = backendVar
 		}
 
+		for _, env := range envVar {
+			kv := strings.SplitN(env, "=", 2)
+			if len(kv) != 2 || !IsValidEnvKey(kv[0]) {
+				printError(fmt.Errorf("--env requires 'KEY=VALUE' format but got '%s'.", env))
+				return ExitErr
+			}
+			task.Env[kv[0]] = kv[1]
+		}
+
 		if len(targetVar) == 0 && len(filterVar) > 0 {
 			printError("--filter must be used with --target option.")
 			return ExitErr
@@ -811,6 +1309,55 @@ func Run(osArgs []string) (exitStatus int) {
 			task.Prefix = prefixStringVar
 		}
 
+		if shellFlag {
+			if len(targetVar) == 0 {
+				printError("--shell must be used with --target option.")
+				return ExitErr
+			}
+
+			// the commands entered in the shell run in parallel like '--exec --parallel --prefix'.
+			task.Parallel = true
+			if !prefixFlag && prefixStringVar == "" {
+				task.UsePrefix = true
+			}
+
+			hosts, err := getTaskHosts(task)
+			if err != nil {
+				printError(err)
+				return ExitErr
+			}
+
+			if err := NewShell(outputConfig, task, hosts, L).Run(os.Stdin); err != nil {
+				printError(err)
+				return ExitErr
+			}
+
+			return
+		}
+
+		if watchVar != "" {
+			interval, err := ParseWatchInterval(watchVar)
+			if err != nil {
+				printError(err)
+				return ExitErr
+			}
+
+			if len(targetVar) == 0 {
+				printError("--watch must be used with --target option.")
+				return ExitErr
+			}
+
+			// the outputs are shown in the table instead of the prefixed lines.
+			task.UsePrefix = false
+
+			if err := runWatch(outputConfig, task, L, command, interval); err != nil {
+				printError(err)
+				return ExitErr
+			}
+
+			return
+		}
+
 		err := runTask(outputConfig, task, []string{}, L)
 		if err != nil {
 			printError(err)
@@ -880,9 +1427,19 @@ func UpdateSSHConfig(outputConfig string, enabledHosts []*Host) ([]byte, error)
 }
 
 func runTask(config string, task *Task, args []string, L *lua.LState) error {
+	return runTaskWithScriptsRunner(config, task, args, L, runTaskScripts, true)
+}
+
+// taskScriptsRunner runs the task's scripts with the hosts and adds the results of the hosts to the result.
+type taskScriptsRunner func(config string, task *Task, hosts []*Host, result *TaskResult) error
+
+// runTaskWithScriptsRunner runs the task like runTask, but the scripts are run by the runner.
+// The params, hooks, known hosts check, audit log and history are processed in the same way regardless of the runner.
+// If record is false, the run is not written to the audit log and the history.
+func runTaskWithScriptsRunner(config string, task *Task, args []string, L *lua.LState, runner taskScriptsRunner, record bool) error {
 	if debugFlag {
 		fmt.Printf("[essh debug] run task: %s\n", task.Name)
-		fmt.Printf("[essh debug] task's args: %v\n", args)
+		fmt.Printf("[essh debug] task's args: %s\n", Secrets.Mask(fmt.Sprintf("%v", args)))
 	}
 
 	if task.Registry != nil {
@@ -890,6 +1447,28 @@ func runTask(config string, task *Task, args []string, L *lua.LState) error {
 		CurrentRegistry = task.Registry
 	}
 
+	// the audit log records the task even if it fails before running the scripts.
+	result := NewTaskResult(task, args, []*Host{})
+	failBeforeRun := func(err error) error {
+		result.Finish(err)
+		if record {
+			writeTaskAuditLog(result)
+		}
+
+		return err
+	}
+
+	// parse params
+	paramValues, args, err := task.ParseParams(args)
+	if err != nil {
+		return failBeforeRun(err)
+	}
+	task.ParamValues = paramValues
+
+	if debugFlag {
+		fmt.Printf("[essh debug] task's params: %s\n", Secrets.Mask(fmt.Sprintf("%v", task.ParamValues)))
+	}
+
 	// compose args
 	argstb := L.NewTable()
 	for i := 0; i < len(args); i++ {
@@ -904,124 +1483,297 @@ func runTask(config string, task *Task, args []string, L *lua.LState) error {
 
 		err := task.Prepare()
 		if err != nil {
-			return err
+			return failBeforeRun(err)
 		}
 	}
 
-	// get target hosts.
+	// the prepare function and the params may change the sensitive values.
+	Secrets.AddSensitiveValues(map[string]*Host{}, map[string]*Task{task.Name: task})
+
+	hosts, err := getTaskHosts(task)
+	if err != nil {
+		return failBeforeRun(err)
+	}
+	result.Hosts = hosts
+
 	if task.IsRemoteTask() {
-		// run remotely.
-		var hosts []*Host
-		if len(task.TargetsSlice()) == 0 {
-			hosts = []*Host{}
-		} else {
-			hosts = NewHostQuery().
-				AppendSelections(task.TargetsSlice()).
-				AppendFilters(task.FiltersSlice()).
-				GetHostsOrderByName()
+		if err := checkHostKeys(hosts); err != nil {
+			return failBeforeRun(err)
+		}
+	}
+
+	if task.Before != nil {
+		if debugFlag {
+			fmt.Printf("[essh debug] run task's before hook.\n")
 		}
 
-		if len(hosts) == 0 {
-			return fmt.Errorf("There are not hosts to run the command. you must specify the valid hosts.")
+		err = task.Before(result)
+	}
+	if err == nil {
+		err = runTaskScriptsWithHostHooks(L, config, task, hosts, result, runner)
+	}
+	result.Finish(err)
+
+	if hookErr := runTaskAfterHooks(task, result); hookErr != nil {
+		if err != nil {
+			fmt.Fprint(os.Stderr, color.FgRB("essh error: %v\n", Secrets.Mask(hookErr.Error())))
+		} else {
+			err = hookErr
 		}
+	}
+
+	if !record {
+		return err
+	}
+
+	writeTaskAuditLog(result)
+
+	if err := AppendHistory(result); err != nil {
+		fmt.Fprint(os.Stderr, color.FgRB("essh error in writing history: %v\n", err))
+	}
 
-		// see https://github.com/kohkimakimoto/essh/issues/38
-		//// handle stdin
-		stdinChs := make([]chan ([]byte), len(hosts))
-		for i, _ := range hosts {
-			stdinChs[i] = make(chan []byte, 256)
+	return err
+}
+
+// writeTaskAuditLog writes the audit record of the task result. The failure of writing is just reported.
+func writeTaskAuditLog(result *TaskResult) {
+	if err := WriteAuditLog(NewTaskAuditRecord(result)); err != nil {
+		fmt.Fprint(os.Stderr, color.FgRB("essh error in writing audit log: %v\n", err))
+	}
+}
+
+// runTaskScriptsWithHostHooks runs the task's scripts.
+// If the task enables host_hooks, the hosts' before_connect hooks run before the scripts,
+// and the after_disconnect hooks run after the scripts even if the scripts failed.
+func runTaskScriptsWithHostHooks(L *lua.LState, config string, task *Task, hosts []*Host, result *TaskResult, runner taskScriptsRunner) error {
+	if !task.HostHooks || !task.IsRemoteTask() {
+		return runner(config, task, hosts, result)
+	}
+
+	connected := []*Host{}
+	var err error
+	for _, host := range hosts {
+		if host.BeforeConnect != nil {
+			if err = host.BeforeConnect(); err != nil {
+				break
+			}
 		}
-		go func() {
-			processStdin(stdinChs)
-		}()
+		if err = runHostHook(L, "before_connect", host.HooksBeforeConnect); err != nil {
+			err = fmt.Errorf("before_connect hook of the host '%s' failed: %v", host.Name, err)
+			break
+		}
+		connected = append(connected, host)
+	}
 
-		wg := &sync.WaitGroup{}
-		m := new(sync.Mutex)
-		for i, host := range hosts {
-			if task.Parallel {
-				wg.Add(1)
-				go func(host *Host) {
-					err := runRemoteTaskScript(config, task, host, hosts, stdinChs[i], m)
-					if err != nil {
-						fmt.Fprintf(os.Stderr, color.FgRB("essh error: %v\n", err))
-						panic(err)
-					}
+	if err == nil {
+		err = runner(config, task, hosts, result)
+	}
+
+	hostResults := map[*Host]*HostResult{}
+	for _, hostResult := range result.HostResults {
+		hostResults[hostResult.Host] = hostResult
+	}
+
+	for _, host := range connected {
+		// the hosts that the scripts did not run with (ex. the task stopped by a failure) also get the result.
+		connectResult := &ConnectResult{
+			ExitCode: ExitErr,
+			Error:    fmt.Errorf("the task '%s' did not run with the host '%s'.", task.Name, host.Name),
+		}
+		if hostResult := hostResults[host]; hostResult != nil {
+			connectResult = &ConnectResult{
+				ExitCode: hostResult.ExitCode,
+				Duration: hostResult.Duration(),
+				Error:    hostResult.Error,
+			}
+		}
 
-					wg.Done()
-				}(host)
+		for _, hookErr := range runAfterDisconnectHooks(L, host, connectResult) {
+			if err != nil {
+				fmt.Fprint(os.Stderr, color.FgRB("essh error: %v\n", Secrets.Mask(hookErr.Error())))
 			} else {
-				err := runRemoteTaskScript(config, task, host, hosts, stdinChs[i], m)
-				if err != nil {
-					return err
-				}
+				err = hookErr
 			}
 		}
-		wg.Wait()
+	}
+
+	return err
+}
+
+// runTaskAfterHooks runs the on_success or on_failure hook, and then the after hook.
+func runTaskAfterHooks(task *Task, result *TaskResult) error {
+	var hook func(result *TaskResult) error
+	if result.Error == nil {
+		hook = task.OnSuccess
 	} else {
-		// run locally.
-		var hosts []*Host
-		if len(task.TargetsSlice()) == 0 {
-			hosts = []*Host{}
-		} else {
-			hosts = NewHostQuery().
-				AppendSelections(task.TargetsSlice()).
-				AppendFilters(task.FiltersSlice()).
-				GetHostsOrderByName()
-		}
+		hook = task.OnFailure
+	}
 
-		if len(task.Targets) >= 1 && len(hosts) == 0 {
-			return fmt.Errorf("There are not hosts to run the command. you must specify the valid hosts.")
+	var err error
+	if hook != nil {
+		if debugFlag {
+			fmt.Printf("[essh debug] run task's %s hook.\n", helper.ConditionString(result.Error == nil, "on_success", "on_failure"))
 		}
 
-		wg := &sync.WaitGroup{}
-		m := new(sync.Mutex)
+		err = hook(result)
+	}
 
-		if len(hosts) == 0 {
-			// local no host task
-			// This pattern should run just exec. should not use magic to pipe stdin to multi targets.
-			err := runLocalTaskScript(config, task, nil, hosts, nil, m)
+	if task.After != nil {
+		if debugFlag {
+			fmt.Printf("[essh debug] run task's after hook.\n")
+		}
+
+		if afterErr := task.After(result); afterErr != nil {
 			if err != nil {
-				return err
+				fmt.Fprint(os.Stderr, color.FgRB("essh error: %v\n", Secrets.Mask(err.Error())))
 			}
-			return nil
+			err = afterErr
 		}
+	}
+
+	return err
+}
 
-		// see https://github.com/kohkimakimoto/essh/issues/38
-		// handle stdin
-		stdinChs := make([]chan ([]byte), len(hosts))
-		for i, _ := range hosts {
-			stdinChs[i] = make(chan []byte, 256)
+func getTaskHosts(task *Task) ([]*Host, error) {
+	var hosts []*Host
+	if task.FixedHosts != nil {
+		hosts = []*Host{}
+		for _, name := range task.FixedHosts {
+			host := Hosts[name]
+			if host == nil {
+				return nil, fmt.Errorf("host '%s' is not defined.", name)
+			}
+			hosts = append(hosts, host)
 		}
-		go func() {
-			processStdin(stdinChs)
-		}()
+	} else if len(task.TargetsSlice()) == 0 {
+		hosts = []*Host{}
+	} else {
+		hosts = NewHostQuery().
+			AppendSelections(task.TargetsSlice()).
+			AppendFilters(task.FiltersSlice()).
+			GetHostsOrderByName()
+	}
 
-		for i, host := range hosts {
-			if task.Parallel {
-				wg.Add(1)
-				go func(host *Host) {
-					err := runLocalTaskScript(config, task, host, hosts, stdinChs[i], m)
-					if err != nil {
-						fmt.Fprintf(os.Stderr, color.FgRB("essh error: %v\n", err))
-						panic(err)
-					}
+	if task.IsRemoteTask() && len(hosts) == 0 {
+		return nil, fmt.Errorf("There are not hosts to run the command. you must specify the valid hosts.")
+	}
 
-					wg.Done()
-				}(host)
-			} else {
-				err := runLocalTaskScript(config, task, host, hosts, stdinChs[i], m)
-				if err != nil {
-					return err
+	if !task.IsRemoteTask() && len(task.Targets) >= 1 && len(hosts) == 0 {
+		return nil, fmt.Errorf("There are not hosts to run the command. you must specify the valid hosts.")
+	}
+
+	return hosts, nil
+}
+
+func runTaskScripts(config string, task *Task, hosts []*Host, result *TaskResult) error {
+	m := new(sync.Mutex)
+
+	if !task.IsRemoteTask() && len(hosts) == 0 {
+		// local no host task
+		// This pattern should run just exec. should not use magic to pipe stdin to multi targets.
+		startTime := time.Now()
+		err := runLocalTaskScript(config, task, nil, hosts, nil, m, os.Stdout, os.Stderr)
+		result.AddHostResult(nil, startTime, err)
+
+		return err
+	}
+
+	// see https://github.com/kohkimakimoto/essh/issues/38
+	// handle stdin
+	stdinChs := make([]chan ([]byte), len(hosts))
+	for i, _ := range hosts {
+		stdinChs[i] = make(chan []byte, 256)
+	}
+	go func() {
+		processStdin(stdinChs)
+	}()
+
+	runScript := func(host *Host, stdinCh chan []byte) error {
+		startTime := time.Now()
+
+		var err error
+		if task.IsRemoteTask() {
+			// run remotely.
+			err = runRemoteTaskScript(config, task, host, hosts, stdinCh, m, os.Stdout, os.Stderr)
+		} else {
+			// run locally.
+			err = runLocalTaskScript(config, task, host, hosts, stdinCh, m, os.Stdout, os.Stderr)
+		}
+		result.AddHostResult(host, startTime, err)
+
+		return err
+	}
+
+	wg := &sync.WaitGroup{}
+	for i, host := range hosts {
+		if task.Parallel {
+			wg.Add(1)
+			go func(host *Host, stdinCh chan []byte) {
+				defer wg.Done()
+
+				if err := runScript(host, stdinCh); err != nil {
+					fmt.Fprintf(os.Stderr, color.FgRB("essh error: %v\n", err))
 				}
+			}(host, stdinChs[i])
+		} else {
+			if err := runScript(host, stdinChs[i]); err != nil {
+				return err
 			}
 		}
-		wg.Wait()
+	}
+	wg.Wait()
+
+	if failed := result.FailedHosts(); len(failed) > 0 {
+		return fmt.Errorf("the task '%s' failed on the hosts: %s", task.Name, strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// runTaskScriptsWithOutputs runs the task's scripts with the hosts without reading stdin.
+// The outputs of each host are written to the writer that is returned by the output function.
+// It returns an error if the scripts failed on some hosts.
+func runTaskScriptsWithOutputs(config string, task *Task, hosts []*Host, result *TaskResult, output func(host *Host) io.Writer) error {
+	m := new(sync.Mutex)
+
+	runScript := func(host *Host) {
+		stdinCh := make(chan []byte)
+		close(stdinCh)
+
+		startTime := time.Now()
+		w := output(host)
+
+		var err error
+		if task.IsRemoteTask() {
+			err = runRemoteTaskScript(config, task, host, hosts, stdinCh, m, w, w)
+		} else {
+			err = runLocalTaskScript(config, task, host, hosts, stdinCh, m, w, w)
+		}
+		result.AddHostResult(host, startTime, err)
+	}
+
+	wg := &sync.WaitGroup{}
+	for _, host := range hosts {
+		if task.Parallel {
+			wg.Add(1)
+			go func(host *Host) {
+				defer wg.Done()
+				runScript(host)
+			}(host)
+		} else {
+			runScript(host)
+		}
+	}
+	wg.Wait()
+
+	if failed := result.FailedHosts(); len(failed) > 0 {
+		return fmt.Errorf("the task '%s' failed on the hosts: %s", task.Name, strings.Join(failed, ", "))
 	}
 
 	return nil
 }
 
-func runRemoteTaskScript(sshConfigPath string, task *Task, host *Host, hosts []*Host, stdinCh chan []byte, m *sync.Mutex) error {
+func runRemoteTaskScript(sshConfigPath string, task *Task, host *Host, hosts []*Host, stdinCh chan []byte, m *sync.Mutex, stdout io.Writer, stderr io.Writer) error {
 	// setup ssh command args
 	var sshCommandArgs []string
 	if task.Pty {
@@ -1041,7 +1793,7 @@ func runRemoteTaskScript(sshConfigPath string, task *Task, host *Host, hosts []*
 	}
 
 	if debugFlag {
-		fmt.Printf("[essh debug] driver: %s \n", driver.Name)
+		fmt.Printf("[essh debug] driver: %s (%s)\n", driver.Name, driver.Source)
 	}
 
 	var script string
@@ -1065,7 +1817,11 @@ func runRemoteTaskScript(sshConfigPath string, task *Task, host *Host, hosts []*
 
 	cmd := exec.Command("ssh", sshCommandArgs[:]...)
 	if debugFlag {
-		fmt.Printf("[essh debug] real ssh command: %v \n", cmd.Args)
+		fmt.Printf("[essh debug] real ssh command: %s \n", Secrets.Mask(fmt.Sprintf("%v", cmd.Args)))
+	}
+
+	if CurrentFakeBackend != nil {
+		return CurrentFakeBackend.Record(task, host, content, cmd.Args)
 	}
 
 	prefix := ""
@@ -1117,31 +1873,35 @@ func runRemoteTaskScript(sshConfigPath string, task *Task, host *Host, hosts []*
 		go handleInput(stdinCh, stdin)
 	}
 
+	// secret values are masked by scanning lines, only if the task uses them.
+	// the pty task keeps the terminal, because it is requested explicitly.
+	direct := len(hosts) <= 1 && prefix == "" && (task.Pty || !Secrets.Contains(script))
+
 	wg := &sync.WaitGroup{}
-	if len(hosts) <= 1 && prefix == "" {
-		cmd.Stdout = os.Stdout
+	if direct {
+		cmd.Stdout = stdout
 	} else {
-		stdout, err := cmd.StdoutPipe()
+		stdoutPipe, err := cmd.StdoutPipe()
 		if err != nil {
 			return err
 		}
 		wg.Add(1)
 		go func() {
-			scanLines(stdout, os.Stdout, prefix, m)
+			scanLines(stdoutPipe, stdout, prefix, m)
 			wg.Done()
 		}()
 	}
 
-	if len(hosts) <= 1 && prefix == "" {
-		cmd.Stderr = os.Stderr
+	if direct {
+		cmd.Stderr = stderr
 	} else {
-		stderr, err := cmd.StderrPipe()
+		stderrPipe, err := cmd.StderrPipe()
 		if err != nil {
 			return err
 		}
 		wg.Add(1)
 		go func() {
-			scanLines(stderr, os.Stderr, prefix, m)
+			scanLines(stderrPipe, stderr, prefix, m)
 			wg.Done()
 		}()
 	}
@@ -1156,7 +1916,7 @@ func runRemoteTaskScript(sshConfigPath string, task *Task, host *Host, hosts []*
 	return cmd.Wait()
 }
 
-func runLocalTaskScript(sshConfigPath string, task *Task, host *Host, hosts []*Host, stdinCh chan []byte, m *sync.Mutex) error {
+func runLocalTaskScript(sshConfigPath string, task *Task, host *Host, hosts []*Host, stdinCh chan []byte, m *sync.Mutex, stdout io.Writer, stderr io.Writer) error {
 	var shell, flag string
 	if runtime.GOOS == "windows" {
 		shell = "cmd"
@@ -1177,7 +1937,7 @@ func runLocalTaskScript(sshConfigPath string, task *Task, host *Host, hosts []*H
 	}
 
 	if debugFlag {
-		fmt.Printf("[essh debug] driver: %s \n", driver.Name)
+		fmt.Printf("[essh debug] driver: %s (%s)\n", driver.Name, driver.Source)
 	}
 
 	var script string
@@ -1197,7 +1957,11 @@ func runLocalTaskScript(sshConfigPath string, task *Task, host *Host, hosts []*H
 
 	cmd := exec.Command(shell, flag, script)
 	if debugFlag {
-		fmt.Printf("[essh debug] real local command: %v \n", cmd.Args)
+		fmt.Printf("[essh debug] real local command: %s \n", Secrets.Mask(fmt.Sprintf("%v", cmd.Args)))
+	}
+
+	if CurrentFakeBackend != nil {
+		return CurrentFakeBackend.Record(task, host, content, cmd.Args)
 	}
 
 	prefix := ""
@@ -1254,31 +2018,35 @@ func runLocalTaskScript(sshConfigPath string, task *Task, host *Host, hosts []*H
 		go handleInput(stdinCh, stdin)
 	}
 
+	// secret values are masked by scanning lines, only if the task uses them.
+	// the pty task keeps the terminal, because it is requested explicitly.
+	direct := len(hosts) <= 1 && prefix == "" && (task.Pty || !Secrets.Contains(script))
+
 	wg := &sync.WaitGroup{}
-	if len(hosts) <= 1 && prefix == "" {
-		cmd.Stdout = os.Stdout
+	if direct {
+		cmd.Stdout = stdout
 	} else {
-		stdout, err := cmd.StdoutPipe()
+		stdoutPipe, err := cmd.StdoutPipe()
 		if err != nil {
 			return err
 		}
 		wg.Add(1)
 		go func() {
-			scanLines(stdout, os.Stdout, prefix, m)
+			scanLines(stdoutPipe, stdout, prefix, m)
 			wg.Done()
 		}()
 	}
 
-	if len(hosts) <= 1 && prefix == "" {
-		cmd.Stderr = os.Stderr
+	if direct {
+		cmd.Stderr = stderr
 	} else {
-		stderr, err := cmd.StderrPipe()
+		stderrPipe, err := cmd.StderrPipe()
 		if err != nil {
 			return err
 		}
 		wg.Add(1)
 		go func() {
-			scanLines(stderr, os.Stderr, prefix, m)
+			scanLines(stderrPipe, stderr, prefix, m)
 			wg.Done()
 		}()
 	}
@@ -1346,9 +2114,9 @@ func scanLines(src io.ReadCloser, dest io.Writer, prefix string, m *sync.Mutex)
 		// prevent mixing data in a line.
 		m.Lock()
 		if prefix != "" {
-			fmt.Fprintf(dest, "%s%s\n", color.FgCB(prefix), scanner.Text())
+			fmt.Fprintf(dest, "%s%s\n", color.FgCB(prefix), Secrets.Mask(scanner.Text()))
 		} else {
-			fmt.Fprintf(dest, "%s\n", scanner.Text())
+			fmt.Fprintf(dest, "%s\n", Secrets.Mask(scanner.Text()))
 		}
 		m.Unlock()
 	}
@@ -1358,54 +2126,58 @@ func scanLines(src io.ReadCloser, dest io.Writer, prefix string, m *sync.Mutex)
 	}
 }
 
-func runSSH(L *lua.LState, config string, args []string) (error, int) {
+func runSSH(L *lua.LState, config string, args []string) (err error, ex int) {
 	// hooks
 	hooks := map[string][]interface{}{}
 
-	// Limitation!
-	// hooks fires only when the hostname is just specified.
-	if len(args) == 1 {
-		hostname := args[0]
-		if host := Hosts[hostname]; host != nil {
-			hooks["before_connect"] = host.HooksBeforeConnect
-			hooks["after_disconnect"] = host.HooksAfterDisconnect
+	// find the destination host in the args like "-p 2222 web01 uptime".
+	hostname, _, hasCommand := ParseSSHDestination(args)
+	host := Hosts[hostname]
+	if host != nil {
+		hooks["before_connect"] = host.HooksBeforeConnect
+		// after_connect hook runs commands before the login shell.
+		// so it does not fire when the command is specified.
+		if !hasCommand {
 			hooks["after_connect"] = host.HooksAfterConnect
 		}
 	}
 
-	// run before_connect hook
-	if before := hooks["before_connect"]; before != nil && len(before) > 0 {
-		if debugFlag {
-			fmt.Printf("[essh debug] run before_connect hook\n")
-		}
-		hookScript, err := getHookScript(L, before)
-		if err != nil {
-			return err, ExitErr
-		}
-		if debugFlag {
-			fmt.Printf("[essh debug] before_connect hook script: %s\n", hookScript)
-		}
-		if err := runCommand(hookScript); err != nil {
+	// run before_connect function in the essh process
+	if host != nil && host.BeforeConnect != nil {
+		if err := host.BeforeConnect(); err != nil {
 			return err, ExitErr
 		}
 	}
 
-	// register after_disconnect hook
+	// run before_connect hook
+	if err := runHostHook(L, "before_connect", hooks["before_connect"]); err != nil {
+		return err, ExitErr
+	}
+
+	// register after_disconnect hooks
+	var connectResult *ConnectResult
 	defer func() {
-		// after hook
-		if after := hooks["after_disconnect"]; after != nil && len(after) > 0 {
-			if debugFlag {
-				fmt.Printf("[essh debug] run after_disconnect hook\n")
-			}
-			hookScript, err := getHookScript(L, after)
-			if err != nil {
-				panic(err)
-			}
-			if debugFlag {
-				fmt.Printf("[essh debug] after_disconnect hook script: %s\n", hookScript)
+		if host == nil {
+			return
+		}
+
+		if connectResult == nil {
+			// ssh did not run (ex. the after_connect hook is invalid).
+			connectResult = &ConnectResult{
+				ExitCode: ExitErr,
+				Error:    fmt.Errorf("did not connect to the host '%s'.", host.Name),
 			}
-			if err := runCommand(hookScript); err != nil {
-				panic(err)
+		}
+
+		// the error of the hooks is returned to the caller instead of the ssh's one.
+		// if the caller already has an error, all errors of the hooks are just printed.
+		hookErrs := runAfterDisconnectHooks(L, host, connectResult)
+		for i, hookErr := range hookErrs {
+			if i == len(hookErrs)-1 && err == nil {
+				err = hookErr
+				ex = ExitErr
+			} else {
+				fmt.Fprint(os.Stderr, color.FgRB("essh error: %v\n", Secrets.Mask(hookErr.Error())))
 			}
 		}
 	}()
@@ -1450,17 +2222,61 @@ func runSSH(L *lua.LState, config string, args []string) (error, int) {
 	cmd.Stderr = os.Stderr
 
 	if debugFlag {
-		fmt.Printf("[essh debug] real ssh command: %v \n", cmd.Args)
+		fmt.Printf("[essh debug] real ssh command: %s \n", Secrets.Mask(fmt.Sprintf("%v", cmd.Args)))
 	}
 
-	err := cmd.Run()
-	ex := wrapcommander.ResolveExitCode(err)
+	record := NewAuditRecord("ssh")
+	record.Args = maskArgs(args)
+	if hostname != "" {
+		record.Hosts = []string{hostname}
+	}
+	record.StartTime = time.Now()
+
+	err = cmd.Run()
+	ex = wrapcommander.ResolveExitCode(err)
+
+	record.EndTime = time.Now()
+	if hostname != "" {
+		record.ExitCodes[hostname] = ex
+	}
+	if err := WriteAuditLog(record); err != nil {
+		fmt.Fprint(os.Stderr, color.FgRB("essh error in writing audit log: %v\n", err))
+	}
+
+	connectResult = &ConnectResult{
+		ExitCode: ex,
+		Duration: record.EndTime.Sub(record.StartTime),
+		Error:    err,
+	}
 
 	// Running as a wrapper of ssh command suppress printing error.
 	// Printing error is essh's behavior. ssh does not have it.
 	return nil, ex
 }
 
+func runHostHook(L *lua.LState, name string, hooks []interface{}) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+
+	if debugFlag {
+		fmt.Printf("[essh debug] run %s hook\n", name)
+	}
+	hookScript, err := getHookScript(L, hooks)
+	if err != nil {
+		return err
+	}
+	if debugFlag {
+		fmt.Printf("[essh debug] %s hook script: %s\n", name, Secrets.Mask(hookScript))
+	}
+
+	if CurrentFakeBackend != nil {
+		return CurrentFakeBackend.RecordHook(name, hookScript)
+	}
+
+	return runCommand(hookScript)
+}
+
 func getHookScript(L *lua.LState, hooks []interface{}) (string, error) {
 	hookScript := ""
 	for _, hook := range hooks {
@@ -1552,6 +2368,31 @@ func (w *CallbackWriter) Write(data []byte) (int, error) {
 	return len(data), nil
 }
 
+// parseEnvOptions removes "--env KEY=VALUE" and "--env=KEY=VALUE" options from the args.
+func parseEnvOptions(args []string) ([]string, []string, error) {
+	envs := []string{}
+	rest := []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		} else if arg == "--env" {
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("--env requires an argument.")
+			}
+			envs = append(envs, args[i+1])
+			i++
+		} else if strings.HasPrefix(arg, "--env=") {
+			envs = append(envs, strings.SplitN(arg, "=", 2)[1])
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+
+	return envs, rest, nil
+}
+
 func printUsage() {
 	fmt.Print(`Usage: essh [<options>] [<ssh options and args...>]
 
@@ -1595,6 +2436,28 @@ Options:
   --all                         (Using with --hosts or --tasks option) Show all that includes hidden objects.
   --tags                        List tags.
   --quiet                       (Using with --hosts, --tasks or --tags option) Show only names. 
+  --explain host|task|driver <name>
+                                Show the layers of the config files that define the object.
+  --lint                        Check the config files and report the problems without running anything.
+  --test [<file|dir>...]        Run the tests of the config that are defined in *_test.lua files with the fake backend.
+  --test-format tap|junit       (Using with --test option) Format of the test results. (default: tap)
+
+  (Tunnels)
+  --tunnels                     List tunnels and the status.
+  --tunnel <name>               Start the tunnel in background.
+  --stop-tunnel <name>          Stop the tunnel.
+
+  (Known Hosts)
+  --scan-host-keys              Collect the host keys and record them to the known_hosts file that is managed by essh.
+  --target <tag|host>           (Using with --scan-host-keys option) Target hosts to scan.
+  --filter <tag|host>           (Using with --scan-host-keys option) Filter target hosts with tags or hosts.
+
+  (History)
+  --history                     List past task runs in the working directory.
+  --all                         (Using with --history option) Show the task runs in all directories.
+  --quiet                       (Using with --history option) Show only ids.
+  --rerun <id>                  Run the task again with the same arguments and hosts.
+  --rerun-failed <id>           Run the task again only with the failed hosts.
 
   (Execute Commands)
   --exec                        Execute commands with the hosts.
@@ -1609,6 +2472,16 @@ Options:
   --pty                         (Using with --exec option) Allocate pseudo-terminal. (add ssh option "-t -t" internally)
   --script-file                 (Using with --exec option) Load commands from a file.
   --driver                      (Using with --exec option) Specify a driver.
+  --env <KEY=VALUE>             (Using with --exec option) Set an environment variable.
+  --watch <interval>            (Using with --exec option) Run the commands periodically and show the results as a table.
+  --pick                        Pick a host from the list interactively, and connect to it.
+                                Using with --exec or --shell option, the host is used as a target.
+  --shell                       Open an interactive shell that runs the entered commands with the target hosts.
+                                It can be used with the options of --exec.
+
+  (Encryption)
+  --encrypt-value [<value>]     Encrypt a value (or stdin) by the key from ESSH_VAULT_KEY or ~/.essh/vault.key.
+  --decrypt-file <file>         Print a decrypted content of the file.
 
   (Completion)
   --zsh-completion              Output zsh completion code.
@@ -1617,13 +2490,71 @@ Options:
 
   (Help)
   --version                     Print version.
-  --help                        Print help.
+  --help [<task>]               Print help. If a task name is specified, print the task's help.
 
 See: https://github.com/kohkimakimoto/essh for updates, code and issues.
 
 `)
 }
 
+func printTaskHelp(task *Task) {
+	usage := "Usage: essh " + task.PublicName()
+	if paramsUsage := task.ParamsUsage(); paramsUsage != "" {
+		usage += " " + paramsUsage
+	}
+	usage += " [<args...>]"
+
+	fmt.Printf("%s\n\n", usage)
+	fmt.Printf("%s\n\n", task.DescriptionOrDefault())
+	if task.LongDescription != "" {
+		fmt.Printf("%s\n\n", strings.TrimSpace(task.LongDescription))
+	}
+
+	if params := task.SortedParams(); len(params) > 0 {
+		fmt.Printf("Params:\n")
+		for _, p := range params {
+			fmt.Printf("  %s\n", strings.TrimRight(fmt.Sprintf("%-30s %s", p.Usage(), p.Description), " "))
+		}
+		fmt.Printf("\n")
+	}
+
+	driver := task.Driver
+	if driver == "" {
+		driver = DefaultDriverName
+	}
+
+	var hosts []*Host
+	if len(task.TargetsSlice()) > 0 {
+		hosts = NewHostQuery().
+			AppendSelections(task.TargetsSlice()).
+			AppendFilters(task.FiltersSlice()).
+			GetHostsOrderByName()
+	}
+	hostNames := []string{}
+	for _, host := range hosts {
+		hostNames = append(hostNames, host.Name)
+	}
+
+	registry := ""
+	if task.Registry != nil {
+		registry = task.Registry.TypeString()
+	}
+
+	fmt.Printf("Settings:\n")
+	fmt.Printf("  %-12s %s\n", "targets:", strings.Join(task.TargetsSlice(), ", "))
+	fmt.Printf("  %-12s %s\n", "filters:", strings.Join(task.FiltersSlice(), ", "))
+	fmt.Printf("  %-12s %s\n", "backend:", task.Backend)
+	fmt.Printf("  %-12s %s\n", "driver:", driver)
+	fmt.Printf("  %-12s %v\n", "parallel:", task.Parallel)
+	fmt.Printf("  %-12s %v\n", "privileged:", task.Privileged)
+	fmt.Printf("  %-12s %s\n", "user:", task.User)
+	fmt.Printf("  %-12s %v\n", "pty:", task.Pty)
+	fmt.Printf("  %-12s %s\n", "hosts:", strings.Join(hostNames, ", "))
+	fmt.Printf("  %-12s %s\n", "registry:", registry)
+	fmt.Printf("  %-12s %s\n", "source:", task.Source)
+	fmt.Printf("\n")
+}
+
 func sprintByTemplate(tmplContent string) (string, error) {
 	tmpl, err := template.New("T").Parse(tmplContent)
 	if err != nil {
@@ -1644,7 +2575,7 @@ func sprintByTemplate(tmplContent string) (string, error) {
 }
 
 func printError(err interface{}) {
-	fmt.Fprintf(os.Stderr, color.FgRB("essh error: %v\n", err))
+	fmt.Fprintf(os.Stderr, color.FgRB("essh error: %s\n", Secrets.Mask(fmt.Sprintf("%v", err))))
 }
 
 func init() {
@@ -1710,6 +2641,24 @@ _essh_tasks_global() {
     _describe -t task "task" __essh_tasks
 }
 
+_essh_namespaces() {
+    local -a __essh_namespaces
+    PRE_IFS=$IFS
+    IFS=$'\n'
+    __essh_namespaces=($({{.Executable}} --zsh-completion-namespaces))
+    IFS=$PRE_IFS
+    _describe -t namespace "namespace" __essh_namespaces -S ':'
+}
+
+_essh_namespaces_global() {
+    local -a __essh_namespaces
+    PRE_IFS=$IFS
+    IFS=$'\n'
+    __essh_namespaces=($({{.Executable}} --global --zsh-completion-namespaces))
+    IFS=$PRE_IFS
+    _describe -t namespace "namespace" __essh_namespaces -S ':'
+}
+
 _essh_tags() {
     local -a __essh_tags
     PRE_IFS=$IFS
@@ -1744,7 +2693,22 @@ _essh_options() {
         '--tasks:List tasks.'
         '--debug:Output debug log.'
         '--global:Force using global config.'
+        '--explain:Show the layers of the config files that define the object.'
+        '--lint:Check the config files.'
+        '--test:Run the tests of the config.'
+        '--test-format:Format of the test results.'
+        '--encrypt-value:Encrypt a value.'
+        '--decrypt-file:Print a decrypted content of the file.'
+        '--history:List past task runs.'
+        '--rerun:Run the task again by the history.'
+        '--rerun-failed:Run the task again only with the failed hosts.'
+        '--tunnels:List tunnels.'
+        '--tunnel:Start the tunnel in background.'
+        '--stop-tunnel:Stop the tunnel.'
+        '--scan-host-keys:Record the host keys.'
         '--exec:Execute commands with the hosts.'
+        '--shell:Open an interactive shell with the hosts.'
+        '--pick:Pick a host interactively.'
         '--zsh-completion:Output zsh completion code.'
         '--bash-completion:Output bash completion code.'
         '--aliases:Output aliases code.'
@@ -1799,6 +2763,8 @@ _essh_exec_options() {
         '--pty:Allocate pseudo-terminal. (add ssh option "-t -t" internally)'
         '--script-file:Load commands from a file.'
         '--driver:Specify a driver.'
+        '--env:Set an environment variable.'
+        '--watch:Run the commands periodically.'
      )
     _describe -t option "option" __essh_options
 }
@@ -1838,6 +2804,7 @@ _essh () {
                     _essh_options
                     ;;
                 *)
+                    _essh_namespaces
                     _essh_tasks
                     _essh_hosts
                     ;;
@@ -1871,9 +2838,11 @@ _essh () {
             case $last_arg in
                 --global)
                     if [ "$globalMode" = "on" ]; then
+                      _essh_namespaces_global
                       _essh_tasks_global
                       _essh_hosts_global
                     else
+                      _essh_namespaces
                       _essh_tasks
                       _essh_hosts
                     fi
@@ -1935,7 +2904,7 @@ _essh_tasks() {
 }
 
 _essh_hosts_and_tasks() {
-    COMPREPLY=( $(compgen -W "$({{.Executable}} --bash-completion-hosts) $({{.Executable}} --bash-completion-tasks)" -- $cur) )
+    COMPREPLY=( $(compgen -W "$({{.Executable}} --bash-completion-hosts) $({{.Executable}} --bash-completion-namespaces | sed 's/$/:/') $({{.Executable}} --bash-completion-tasks)" -- $cur) )
 }
 
 _essh_hosts_and_tags() {
@@ -1996,6 +2965,8 @@ _essh_exec_options() {
         '--pty:Allocate pseudo-terminal. (add ssh option "-t -t" internally)'
         '--script-file:Load commands from a file.'
         '--driver:Specify a driver.'
+        '--env:Set an environment variable.'
+        '--watch:Run the commands periodically.'
      )
     _describe -t option "option" __essh_options
 }
@@ -2009,6 +2980,19 @@ _essh_options() {
         --no-color
         --gen
         --global
+        --explain
+        --lint
+        --test
+        --test-format
+        --encrypt-value
+        --decrypt-file
+        --history
+        --rerun
+        --rerun-failed
+        --tunnels
+        --tunnel
+        --stop-tunnel
+        --scan-host-keys
         --working-dir
         --config
         --hosts
@@ -2016,6 +3000,8 @@ _essh_options() {
         --tasks
         --debug
         --exec
+        --shell
+        --pick
         --zsh-completion
         --bash-completion
         --aliases
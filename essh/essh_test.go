@@ -0,0 +1,24 @@
+package essh
+
+import (
+	"github.com/yuin/gopher-lua"
+	"os"
+	"path/filepath"
+)
+
+// newTestLState resets the resources and returns a Lua state that loads config code like Run does.
+// The caller must close the state.
+func newTestLState() *lua.LState {
+	initResources()
+
+	WorkingDataDir = filepath.Join(os.TempDir(), "essh-test", ".essh")
+	GlobalRegistry = NewRegistry(filepath.Join(os.TempDir(), "essh-test-global"), RegistryTypeGlobal)
+	LocalRegistry = NewRegistry(WorkingDataDir, RegistryTypeLocal)
+	CurrentRegistry = LocalRegistry
+	LoadingConfig = true
+
+	L := lua.NewState()
+	InitLuaState(L)
+
+	return L
+}
@@ -0,0 +1,149 @@
+package essh
+
+import (
+	"fmt"
+	"github.com/yuin/gopher-lua"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ExplainLayer is a definition of a host, task or driver in a config file.
+// Same name resources are stored in the layered structure that uses Parent and Child.
+type ExplainLayer struct {
+	Source   string
+	Registry *Registry
+	LValues  map[string]lua.LValue
+}
+
+func explainHostLayers(host *Host) []*ExplainLayer {
+	// the bottom layer is the first defined one.
+	for host.Child != nil {
+		host = host.Child
+	}
+
+	layers := []*ExplainLayer{}
+	for ; host != nil; host = host.Parent {
+		layers = append(layers, &ExplainLayer{Source: host.Source, Registry: host.Registry, LValues: host.LValues})
+	}
+
+	return layers
+}
+
+func explainTaskLayers(task *Task) []*ExplainLayer {
+	for task.Child != nil {
+		task = task.Child
+	}
+
+	layers := []*ExplainLayer{}
+	for ; task != nil; task = task.Parent {
+		layers = append(layers, &ExplainLayer{Source: task.Source, Registry: task.Registry, LValues: task.LValues})
+	}
+
+	return layers
+}
+
+func explainDriverLayers(driver *Driver) []*ExplainLayer {
+	for driver.Child != nil {
+		driver = driver.Child
+	}
+
+	layers := []*ExplainLayer{}
+	for ; driver != nil; driver = driver.Parent {
+		layers = append(layers, &ExplainLayer{Source: driver.Source, Registry: driver.Registry, LValues: driver.LValues})
+	}
+
+	return layers
+}
+
+func explainResource(w io.Writer, kind string, name string) error {
+	var layers []*ExplainLayer
+
+	switch kind {
+	case "host":
+		host := Hosts[name]
+		if host == nil {
+			return fmt.Errorf("host '%s' is not defined.", name)
+		}
+		layers = explainHostLayers(host)
+	case "task":
+		task := Tasks[name]
+		if task == nil {
+			return fmt.Errorf("task '%s' is not defined.", name)
+		}
+		layers = explainTaskLayers(task)
+	case "driver":
+		driver := Drivers[name]
+		if driver == nil {
+			return fmt.Errorf("driver '%s' is not defined.", name)
+		}
+		layers = explainDriverLayers(driver)
+	default:
+		return fmt.Errorf("--explain supports only 'host', 'task' or 'driver' but got '%s'.", kind)
+	}
+
+	fmt.Fprintf(w, "%s '%s' is defined in %d layer(s). The last defined one is effective.\n\n", kind, name, len(layers))
+
+	for i, layer := range layers {
+		label := layer.Source
+		if label == "" {
+			label = "(built-in)"
+		}
+		if layer.Registry != nil {
+			label += " (" + layer.Registry.TypeString() + ")"
+		}
+		if i == len(layers)-1 {
+			label += " <- effective"
+		}
+
+		fmt.Fprintf(w, "[%d] %s\n", i+1, label)
+		writeExplainFields(w, layer.LValues)
+		fmt.Fprintf(w, "\n")
+	}
+
+	return nil
+}
+
+func writeExplainFields(w io.Writer, lvalues map[string]lua.LValue) {
+	keys := []string{}
+	for key, _ := range lvalues {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(w, "    %s = %s\n", key, Secrets.Mask(formatLValue(lvalues[key])))
+	}
+}
+
+func formatLValue(lv lua.LValue) string {
+	switch v := lv.(type) {
+	case lua.LString:
+		return fmt.Sprintf("%q", string(v))
+	case *lua.LFunction:
+		return "function"
+	case *lua.LTable:
+		maxn := v.MaxN()
+		items := []string{}
+		if maxn == 0 {
+			keys := []string{}
+			values := map[string]lua.LValue{}
+			v.ForEach(func(key, value lua.LValue) {
+				keystr := key.String()
+				keys = append(keys, keystr)
+				values[keystr] = value
+			})
+			sort.Strings(keys)
+			for _, key := range keys {
+				items = append(items, key+" = "+formatLValue(values[key]))
+			}
+		} else {
+			for i := 1; i <= maxn; i++ {
+				items = append(items, formatLValue(v.RawGetInt(i)))
+			}
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	default:
+		return lv.String()
+	}
+}
@@ -0,0 +1,107 @@
+package essh
+
+import (
+	"bytes"
+	"github.com/yuin/gopher-lua"
+	"testing"
+)
+
+func TestExplainResource(t *testing.T) {
+	initResources()
+
+	global := NewHost()
+	global.Name = "web01"
+	global.Source = "/home/user/.essh/config.lua:1"
+	global.Registry = NewRegistry("/home/user/.essh", RegistryTypeGlobal)
+	global.LValues = map[string]lua.LValue{
+		"HostName": lua.LString("192.168.0.11"),
+		"tags":     lua.LString("web"),
+	}
+
+	local := NewHost()
+	local.Name = "web01"
+	local.Source = "esshconfig.lua:3"
+	local.Registry = NewRegistry("/path/to/project/.essh", RegistryTypeLocal)
+	local.LValues = map[string]lua.LValue{
+		"HostName": lua.LString("192.168.0.21"),
+		"port":     lua.LNumber(2222),
+	}
+
+	local.Child = global
+	global.Parent = local
+	Hosts["web01"] = local
+
+	var b bytes.Buffer
+	if err := explainResource(&b, "host", "web01"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `host 'web01' is defined in 2 layer(s). The last defined one is effective.
+
+[1] /home/user/.essh/config.lua:1 (global)
+    HostName = "192.168.0.11"
+    tags = "web"
+
+[2] esshconfig.lua:3 (local) <- effective
+    HostName = "192.168.0.21"
+    port = 2222
+
+`
+	if b.String() != expected {
+		t.Errorf("expected:\n%s\nbut got:\n%s", expected, b.String())
+	}
+}
+
+func TestExplainResourceErrors(t *testing.T) {
+	initResources()
+
+	cases := []struct {
+		kind  string
+		name  string
+		error string
+	}{
+		{kind: "host", name: "undefined", error: "host 'undefined' is not defined."},
+		{kind: "task", name: "undefined", error: "task 'undefined' is not defined."},
+		{kind: "driver", name: "undefined", error: "driver 'undefined' is not defined."},
+		{kind: "tunnel", name: "db", error: "--explain supports only 'host', 'task' or 'driver' but got 'tunnel'."},
+	}
+
+	for _, c := range cases {
+		var b bytes.Buffer
+		err := explainResource(&b, c.kind, c.name)
+		if err == nil || err.Error() != c.error {
+			t.Errorf("%s '%s': expected error %q but got %v", c.kind, c.name, c.error, err)
+		}
+	}
+}
+
+func TestFormatLValue(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	list := L.NewTable()
+	list.Append(lua.LString("web"))
+	list.Append(lua.LString("db"))
+
+	dict := L.NewTable()
+	dict.RawSetString("user", lua.LString("deploy"))
+	dict.RawSetString("port", lua.LNumber(22))
+
+	cases := []struct {
+		value    lua.LValue
+		expected string
+	}{
+		{value: lua.LString("web01"), expected: `"web01"`},
+		{value: lua.LNumber(22), expected: "22"},
+		{value: lua.LTrue, expected: "true"},
+		{value: L.NewFunction(func(L *lua.LState) int { return 0 }), expected: "function"},
+		{value: list, expected: `{"web", "db"}`},
+		{value: dict, expected: `{port = 22, user = "deploy"}`},
+	}
+
+	for _, c := range cases {
+		if actual := formatLValue(c.value); actual != c.expected {
+			t.Errorf("expected %s but got %s", c.expected, actual)
+		}
+	}
+}
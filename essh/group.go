@@ -81,13 +81,15 @@ func setupGroup(L *lua.LState, group *Group, config *lua.LTable) {
 			// set a host, task or driver
 			lv, ok := v.(*lua.LUserData)
 			if !ok {
-				panic(fmt.Sprintf("expected userdata (host, task or driver) but got '%v'\n", v))
+				groupError(L, "expected host, task or driver but got %s.", describeLValue(v))
+				return
 			}
 
 			switch resource := lv.Value.(type) {
 			case *Host:
 				if group.Type != GroupTypeUndefined && group.Type != GroupTypeHosts {
-					panic("group can use only one type of resources. \n")
+					groupError(L, "group can use only one type of resources.")
+					return
 				}
 
 				// set host table data
@@ -96,7 +98,8 @@ func setupGroup(L *lua.LState, group *Group, config *lua.LTable) {
 				}
 				hosts, ok := toLTable(group.LValues["hosts"])
 				if !ok {
-					panic("broken 'hosts' table")
+					groupError(L, "'hosts' must be a table but got %s.", describeLValue(group.LValues["hosts"]))
+					return
 				}
 				host := L.NewTable()
 				resource.MapLValuesToLTable(host)
@@ -106,7 +109,8 @@ func setupGroup(L *lua.LState, group *Group, config *lua.LTable) {
 				group.RegisterHost(resource)
 			case *Task:
 				if group.Type != GroupTypeUndefined && group.Type != GroupTypeTasks {
-					panic("group can use only one type of resources. \n")
+					groupError(L, "group can use only one type of resources.")
+					return
 				}
 
 				// set task table data
@@ -115,7 +119,8 @@ func setupGroup(L *lua.LState, group *Group, config *lua.LTable) {
 				}
 				tasks, ok := toLTable(group.LValues["tasks"])
 				if !ok {
-					panic("broken 'tasks' table")
+					groupError(L, "'tasks' must be a table but got %s.", describeLValue(group.LValues["tasks"]))
+					return
 				}
 				task := L.NewTable()
 				resource.MapLValuesToLTable(task)
@@ -125,7 +130,8 @@ func setupGroup(L *lua.LState, group *Group, config *lua.LTable) {
 				group.RegisterTask(resource)
 			case *Driver:
 				if group.Type != GroupTypeUndefined && group.Type != GroupTypeDrivers {
-					panic("group can use only one type of resources. \n")
+					groupError(L, "group can use only one type of resources.")
+					return
 				}
 
 				// set task table data
@@ -134,7 +140,8 @@ func setupGroup(L *lua.LState, group *Group, config *lua.LTable) {
 				}
 				drivers, ok := toLTable(group.LValues["drivers"])
 				if !ok {
-					panic("broken 'drivers' table")
+					groupError(L, "'drivers' must be a table but got %s.", describeLValue(group.LValues["drivers"]))
+					return
 				}
 				driver := L.NewTable()
 				resource.MapLValuesToLTable(driver)
@@ -143,10 +150,10 @@ func setupGroup(L *lua.LState, group *Group, config *lua.LTable) {
 				// register task object
 				group.RegisterDriver(resource)
 			default:
-				panic(fmt.Sprintf("expected host, task or driver but got '%v'\n", resource))
+				groupError(L, "expected host, task or driver but got %s.", describeLValue(v))
 			}
 		} else {
-			panic("invalid operation\n")
+			groupError(L, "keys must be strings or numbers but got %s.", describeLValue(k))
 		}
 	})
 
@@ -160,7 +167,8 @@ func updateGroup(L *lua.LState, group *Group, key string, value lua.LValue) {
 	case "hosts":
 		if tb, ok := toLTable(value); ok {
 			if group.Type != GroupTypeUndefined && group.Type != GroupTypeHosts {
-				panic("group can use only one type of resources. \n")
+				groupError(L, "group can use only one type of resources.")
+				return
 			}
 
 			// initialize
@@ -169,12 +177,14 @@ func updateGroup(L *lua.LState, group *Group, key string, value lua.LValue) {
 			tb.ForEach(func(k, v lua.LValue) {
 				name, ok := toString(k)
 				if !ok {
-					panic(fmt.Sprintf("expected string of host's name but got '%v'\n", k))
+					groupError(L, "host's name must be a string but got %s.", describeLValue(k))
+					return
 				}
 
 				config, ok := toLTable(v)
 				if !ok {
-					panic(fmt.Sprintf("expected table of host's config but got '%v'\n", v))
+					groupError(L, "host '%s' config must be a table but got %s.", name, describeLValue(v))
+					return
 				}
 
 				h := registerHost(L, name)
@@ -182,12 +192,13 @@ func updateGroup(L *lua.LState, group *Group, key string, value lua.LValue) {
 				group.RegisterHost(h)
 			})
 		} else {
-			panic(fmt.Sprintf("expected table but got '%v'\n", value))
+			groupError(L, "'%s' must be a table but got %s.", key, describeLValue(value))
 		}
 	case "tasks":
 		if tb, ok := toLTable(value); ok {
 			if group.Type != GroupTypeUndefined && group.Type != GroupTypeTasks {
-				panic("group can use only one type of resources. \n")
+				groupError(L, "group can use only one type of resources.")
+				return
 			}
 
 			// initialize
@@ -196,12 +207,14 @@ func updateGroup(L *lua.LState, group *Group, key string, value lua.LValue) {
 			tb.ForEach(func(k, v lua.LValue) {
 				name, ok := toString(k)
 				if !ok {
-					panic(fmt.Sprintf("expected string of task's name but got '%v'\n", k))
+					groupError(L, "task's name must be a string but got %s.", describeLValue(k))
+					return
 				}
 
 				config, ok := toLTable(v)
 				if !ok {
-					panic(fmt.Sprintf("expected table of task's config but got '%v'\n", v))
+					groupError(L, "task '%s' config must be a table but got %s.", name, describeLValue(v))
+					return
 				}
 
 				t := registerTask(L, name)
@@ -209,12 +222,13 @@ func updateGroup(L *lua.LState, group *Group, key string, value lua.LValue) {
 				group.RegisterTask(t)
 			})
 		} else {
-			panic(fmt.Sprintf("expected table but got '%v'\n", value))
+			groupError(L, "'%s' must be a table but got %s.", key, describeLValue(value))
 		}
 	case "drivers":
 		if tb, ok := toLTable(value); ok {
 			if group.Type != GroupTypeUndefined && group.Type != GroupTypeDrivers {
-				panic("group can use only one type of resources. \n")
+				groupError(L, "group can use only one type of resources.")
+				return
 			}
 
 			// initialize
@@ -225,12 +239,14 @@ func updateGroup(L *lua.LState, group *Group, key string, value lua.LValue) {
 			tb.ForEach(func(k, v lua.LValue) {
 				name, ok := toString(k)
 				if !ok {
-					panic(fmt.Sprintf("expected string of driver's name but got '%v'\n", k))
+					groupError(L, "driver's name must be a string but got %s.", describeLValue(k))
+					return
 				}
 
 				config, ok := toLTable(v)
 				if !ok {
-					panic(fmt.Sprintf("expected table of driver's config but got '%v'\n", v))
+					groupError(L, "driver '%s' config must be a table but got %s.", name, describeLValue(v))
+					return
 				}
 
 				d := registerDriver(L, name)
@@ -238,11 +254,15 @@ func updateGroup(L *lua.LState, group *Group, key string, value lua.LValue) {
 				group.RegisterDriver(d)
 			})
 		} else {
-			panic(fmt.Sprintf("expected table but got '%v'\n", value))
+			groupError(L, "'%s' must be a table but got %s.", key, describeLValue(value))
 		}
 	}
 }
 
+func groupError(L *lua.LState, format string, a ...interface{}) {
+	addConfigError(L, &ConfigError{Resource: "group", Message: fmt.Sprintf(format, a...)})
+}
+
 func applyGroupDefaultValues(L *lua.LState, group *Group) {
 
 	isSkipKey := func(k string) bool {
@@ -0,0 +1,293 @@
+package essh
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HistoryFile is a path to the file that stores the records of the task runs.
+var HistoryFile string
+
+// HistoryMaxRecords is the number of the recent records that are listed by --history.
+var HistoryMaxRecords = 1000
+
+// HistoryFileMaxRecords is the number of the recent records that are kept in the history file.
+var HistoryFileMaxRecords = 10000
+
+const (
+	HistoryStatusSuccess = "success"
+	HistoryStatusFailed  = "failed"
+)
+
+// HistoryRecord is a record of a task run. The history file is an append-only JSON lines file,
+// and the ID of a record is its line number, so that concurrent runs don't need to rewrite the file.
+type HistoryRecord struct {
+	ID             int            `json:"-"`
+	Task           string         `json:"task"`
+	Command        string         `json:"command,omitempty"`
+	Args           []string       `json:"args"`
+	Hosts          []string       `json:"hosts"`
+	FailedHosts    []string       `json:"failed_hosts"`
+	SucceededHosts []string       `json:"succeeded_hosts"`
+	ExitCodes      map[string]int `json:"exit_codes"`
+	Status         string         `json:"status"`
+	WorkingDir     string         `json:"working_dir"`
+	StartTime      time.Time      `json:"start_time"`
+	EndTime        time.Time      `json:"end_time"`
+}
+
+func (r *HistoryRecord) Duration() time.Duration {
+	return r.EndTime.Sub(r.StartTime)
+}
+
+// historyHeader is the first line of the trimmed history file.
+// The IDs of the records are kept by it after the old records are removed.
+type historyHeader struct {
+	FirstID int `json:"first_id"`
+}
+
+const historyHeaderPrefix = `{"first_id":`
+
+func LoadHistory() ([]*HistoryRecord, error) {
+	records := []*HistoryRecord{}
+
+	f, err := os.Open(HistoryFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return records, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	// the ID of the record is the line number, or the position from the header in the trimmed file.
+	offset := 0
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if n == 1 && strings.HasPrefix(line, historyHeaderPrefix) {
+			header := &historyHeader{}
+			if err := json.Unmarshal([]byte(line), header); err != nil {
+				return nil, fmt.Errorf("broken history file %s: %v", HistoryFile, err)
+			}
+			offset = header.FirstID - 2
+			continue
+		}
+
+		record := &HistoryRecord{}
+		if err := json.Unmarshal([]byte(line), record); err != nil {
+			return nil, fmt.Errorf("broken history file %s: %v", HistoryFile, err)
+		}
+		record.ID = n + offset
+		records = append(records, record)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func FindHistoryRecord(id int) (*HistoryRecord, error) {
+	records, err := LoadHistory()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, record := range records {
+		if record.ID == id {
+			return record, nil
+		}
+	}
+
+	return nil, fmt.Errorf("history '%d' is not found.", id)
+}
+
+// AppendHistory appends a record of the task run to the history file.
+// The arguments are masked, because the history file is kept after the run.
+func AppendHistory(result *TaskResult) error {
+	if HistoryFile == "" {
+		return nil
+	}
+
+	record := &HistoryRecord{
+		Task:           result.Task.Name,
+		Args:           maskArgs(result.Args),
+		Hosts:          result.HostNames(),
+		FailedHosts:    result.FailedHosts(),
+		SucceededHosts: result.SucceededHosts(),
+		ExitCodes:      result.ExitCodes(),
+		Status:         HistoryStatusSuccess,
+		WorkingDir:     WorkingDir,
+		StartTime:      result.StartTime,
+		EndTime:        result.EndTime,
+	}
+	if result.Error != nil {
+		record.Status = HistoryStatusFailed
+	}
+	if result.Task.Name == ExecTaskName {
+		record.Command = NewTaskAuditRecord(result).Command
+	}
+
+	if err := os.MkdirAll(filepath.Dir(HistoryFile), os.FileMode(0755)); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	// a line is appended by a single write, so the records of concurrent runs are not mixed.
+	f, err := os.OpenFile(HistoryFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+
+	return trimHistory()
+}
+
+// trimHistory removes the old records if the history file has more than HistoryFileMaxRecords records.
+// The file is replaced with a new one that has the header and the recent records.
+// A record that another run appends while replacing the file may be lost, but it happens only at the trimming.
+func trimHistory() error {
+	if HistoryFileMaxRecords <= 0 {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(HistoryFile)
+	if err != nil {
+		return err
+	}
+	lines := bytes.Count(b, []byte("\n"))
+	if bytes.HasPrefix(b, []byte(historyHeaderPrefix)) {
+		lines--
+	}
+	if lines <= HistoryFileMaxRecords {
+		return nil
+	}
+
+	records, err := LoadHistory()
+	if err != nil {
+		return err
+	}
+	if len(records) <= HistoryFileMaxRecords {
+		return nil
+	}
+	records = records[len(records)-HistoryFileMaxRecords:]
+
+	header, err := json.Marshal(&historyHeader{FirstID: records[0].ID})
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(append(header, '\n'))
+	id := records[0].ID
+	for _, record := range records {
+		// empty lines keep the IDs of the records after the removed lines.
+		for ; id < record.ID; id++ {
+			buf.WriteString("\n")
+		}
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(line, '\n'))
+		id++
+	}
+
+	tmp := HistoryFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, HistoryFile)
+}
+
+// rerunTask gets the task and the arguments to run again by the history.
+// If failedID is specified, the task runs only with the failed hosts.
+func rerunTask(id string, failedID string) (*Task, []string, error) {
+	onlyFailed := false
+	if failedID != "" {
+		id = failedID
+		onlyFailed = true
+	}
+
+	n, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, nil, fmt.Errorf("history id must be a number but got '%s'.", id)
+	}
+
+	record, err := FindHistoryRecord(n)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if record.WorkingDir != WorkingDir {
+		return nil, nil, fmt.Errorf("history '%d' was run in '%s'. you must rerun it in the same directory.", record.ID, record.WorkingDir)
+	}
+
+	if record.Task == ExecTaskName {
+		return nil, nil, fmt.Errorf("history '%d' is a run of --exec. only tasks can be rerun.", record.ID)
+	}
+
+	for _, arg := range record.Args {
+		if strings.Contains(arg, SecretMask) {
+			return nil, nil, fmt.Errorf("history '%d' has masked arguments. run the task with the arguments again.", record.ID)
+		}
+	}
+
+	task := GetEnabledTask(record.Task)
+	if task == nil {
+		return nil, nil, fmt.Errorf("task '%s' is not defined.", record.Task)
+	}
+
+	// use the resolved hosts in the history even if the tags have been changed.
+	task.FixedHosts = record.Hosts
+	if onlyFailed {
+		if record.Status != HistoryStatusFailed {
+			return nil, nil, fmt.Errorf("history '%d' does not have failures.", record.ID)
+		}
+
+		if len(record.Hosts) > 0 {
+			// the hosts that were not run because of the failure of the other host are also rerun.
+			succeeded := map[string]bool{}
+			for _, name := range record.SucceededHosts {
+				succeeded[name] = true
+			}
+
+			task.FixedHosts = []string{}
+			for _, name := range record.Hosts {
+				if !succeeded[name] {
+					task.FixedHosts = append(task.FixedHosts, name)
+				}
+			}
+			if len(task.FixedHosts) == 0 {
+				return nil, nil, fmt.Errorf("history '%d' does not have failed hosts.", record.ID)
+			}
+		}
+	}
+
+	return task, record.Args, nil
+}
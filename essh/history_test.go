@@ -0,0 +1,167 @@
+package essh
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newHistoryTestResult(task *Task, args []string, hostNames []string, failed map[string]bool) *TaskResult {
+	hosts := []*Host{}
+	for _, name := range hostNames {
+		host := NewHost()
+		host.Name = name
+		hosts = append(hosts, host)
+	}
+
+	result := NewTaskResult(task, args, hosts)
+	var err error
+	for _, host := range hosts {
+		if _, ok := failed[host.Name]; !ok {
+			// not run after the failure.
+			continue
+		}
+		var hostErr error
+		if failed[host.Name] {
+			hostErr = errors.New("failed")
+			err = hostErr
+		}
+		result.AddHostResult(host, time.Now(), hostErr)
+	}
+	result.Finish(err)
+
+	return result
+}
+
+func TestHistoryRerunFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "essh-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	initResources()
+	defer initResources()
+
+	HistoryFile = filepath.Join(dir, "history")
+	defer func() {
+		HistoryFile = ""
+	}()
+	WorkingDir = dir
+
+	task := NewTask()
+	task.Name = "deploy"
+	Tasks[task.Name] = task
+	Secrets.Add("token", "s3cr3t-token")
+
+	// web02 failed and web03 was not run.
+	results := []*TaskResult{
+		newHistoryTestResult(task, []string{"--env=prod"}, []string{"web01", "web02", "web03"}, map[string]bool{"web01": false, "web02": true}),
+		newHistoryTestResult(task, []string{"--token=s3cr3t-token"}, []string{"web01"}, map[string]bool{"web01": false}),
+		newHistoryTestResult(task, []string{}, []string{"web01"}, map[string]bool{"web01": false}),
+	}
+	for _, result := range results {
+		if err := AppendHistory(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := LoadHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0].ID != 1 || records[2].ID != 3 {
+		t.Fatalf("unexpected records %v", records)
+	}
+	if !reflect.DeepEqual(records[1].Args, []string{"--token=" + SecretMask}) {
+		t.Errorf("expected masked args but got %v", records[1].Args)
+	}
+
+	cases := []struct {
+		id       string
+		failedID string
+		hosts    []string
+		args     []string
+		err      string
+	}{
+		{id: "1", hosts: []string{"web01", "web02", "web03"}, args: []string{"--env=prod"}},
+		{failedID: "1", hosts: []string{"web02", "web03"}, args: []string{"--env=prod"}},
+		{id: "2", err: "has masked arguments"},
+		{failedID: "3", err: "does not have failures"},
+		{id: "4", err: "is not found"},
+	}
+
+	for _, c := range cases {
+		rerun, args, err := rerunTask(c.id, c.failedID)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s/%s: expected error containing %q but got %v", c.id, c.failedID, c.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s/%s: %v", c.id, c.failedID, err)
+			continue
+		}
+		if !reflect.DeepEqual(rerun.FixedHosts, c.hosts) || !reflect.DeepEqual(args, c.args) {
+			t.Errorf("%s/%s: unexpected hosts %v and args %v", c.id, c.failedID, rerun.FixedHosts, args)
+		}
+	}
+}
+
+func TestTrimHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "essh-history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	HistoryFile = filepath.Join(dir, "history")
+	HistoryFileMaxRecords = 3
+	defer func() {
+		HistoryFile = ""
+		HistoryFileMaxRecords = 10000
+	}()
+
+	task := NewTask()
+	task.Name = "deploy"
+
+	for i := 1; i <= 6; i++ {
+		result := newHistoryTestResult(task, []string{fmt.Sprintf("%d", i)}, []string{"web01"}, map[string]bool{"web01": false})
+		if err := AppendHistory(result); err != nil {
+			t.Fatal(err)
+		}
+
+		records, err := LoadHistory()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids := []int{}
+		for _, record := range records {
+			ids = append(ids, record.ID)
+			// the IDs are kept after trimming.
+			if record.Args[0] != fmt.Sprintf("%d", record.ID) {
+				t.Errorf("expected the record %d has the args %d but got %v", record.ID, record.ID, record.Args)
+			}
+		}
+
+		first := 1
+		if i > 3 {
+			first = i - 2
+		}
+		expected := []int{}
+		for id := first; id <= i; id++ {
+			expected = append(expected, id)
+		}
+		if !reflect.DeepEqual(ids, expected) {
+			t.Errorf("expected the IDs %v but got %v", expected, ids)
+		}
+	}
+}
@@ -14,14 +14,21 @@ type Host struct {
 	Name                 string
 	Description          string
 	Props                map[string]string
+	Env                  map[string]string
+	Sensitive            []string
 	HooksBeforeConnect   []interface{}
 	HooksAfterConnect    []interface{}
 	HooksAfterDisconnect []interface{}
+	Jump                 []string
+	Socks                string
+	BeforeConnect        func() error
+	AfterDisconnect      func(result *ConnectResult) error
 	Hidden               bool
 	Tags                 []string
 	SSHConfig            map[string]string
 	Registry             *Registry
 	Group                *Group
+	Source               string
 	LValues              map[string]lua.LValue
 	// If you define same name hosts in multi time, stores it in layered structure that uses Parent and Child.
 	Parent *Host
@@ -33,10 +40,13 @@ var Hosts map[string]*Host
 func NewHost() *Host {
 	return &Host{
 		Props:                map[string]string{},
+		Env:                  map[string]string{},
+		Sensitive:            []string{},
 		HooksBeforeConnect:   []interface{}{},
 		HooksAfterConnect:    []interface{}{},
 		HooksAfterDisconnect: []interface{}{},
 		Tags:                 []string{},
+		Jump:                 []string{},
 		SSHConfig:            map[string]string{},
 		LValues:              map[string]lua.LValue{},
 	}
@@ -53,14 +63,15 @@ func (h *Host) SortedSSHConfig() []map[string]string {
 
 	var names []string
 
-	for name, _ := range h.SSHConfig {
+	sshConfig := h.SSHConfigWithFields()
+	for name, _ := range sshConfig {
 		names = append(names, name)
 	}
 
 	sort.Strings(names)
 
 	for _, name := range names {
-		v := h.SSHConfig[name]
+		v := sshConfig[name]
 		value := map[string]string{name: v}
 		values = append(values, value)
 	}
@@ -68,6 +79,84 @@ func (h *Host) SortedSSHConfig() []map[string]string {
 	return values
 }
 
+// SSHConfigWithFields returns the ssh_config that includes the values translated from the jump and socks,
+// and the known_hosts file that is managed by essh.
+func (h *Host) SSHConfigWithFields() map[string]string {
+	sshConfig := map[string]string{}
+	for k, v := range h.SSHConfig {
+		sshConfig[k] = v
+	}
+
+	if len(h.Jump) > 0 {
+		sshConfig["ProxyJump"] = strings.Join(h.Jump, ",")
+	}
+
+	if h.Socks != "" {
+		sshConfig["DynamicForward"] = h.Socks
+	}
+
+	if path := h.KnownHostsFile(); path != "" && h.sshConfigValue("UserKnownHostsFile") == "" {
+		sshConfig["UserKnownHostsFile"] = path
+	}
+
+	return sshConfig
+}
+
+// ValidateJump checks the jump hosts are defined and do not make a loop.
+func (h *Host) ValidateJump() error {
+	if len(h.Jump) > 0 {
+		if _, ok := h.SSHConfig["ProxyJump"]; ok {
+			return fmt.Errorf("host '%s' can't use 'jump' and 'ProxyJump' at the same time (%s).", h.Name, h.Source)
+		}
+		if _, ok := h.SSHConfig["ProxyCommand"]; ok {
+			return fmt.Errorf("host '%s' can't use 'jump' and 'ProxyCommand' at the same time (%s).", h.Name, h.Source)
+		}
+	}
+
+	if h.Socks != "" {
+		if _, ok := h.SSHConfig["DynamicForward"]; ok {
+			return fmt.Errorf("host '%s' can't use 'socks' and 'DynamicForward' at the same time (%s).", h.Name, h.Source)
+		}
+	}
+
+	return h.validateJumpChain([]string{h.Name})
+}
+
+func (h *Host) validateJumpChain(chain []string) error {
+	for _, name := range h.Jump {
+		jumpHost := Hosts[name]
+		if jumpHost == nil {
+			return fmt.Errorf("host '%s' uses the jump host '%s' that is not defined (%s).", h.Name, name, h.Source)
+		}
+
+		for _, c := range chain {
+			if c == name {
+				return fmt.Errorf("host '%s' has a loop of the jump hosts: %s -> %s", chain[0], strings.Join(chain, " -> "), name)
+			}
+		}
+
+		if err := jumpHost.validateJumpChain(append(chain, name)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SensitiveValues returns the values of the props, env and ssh_config that are declared as sensitive.
+func (h *Host) SensitiveValues() []string {
+	values := []string{}
+	for _, key := range h.Sensitive {
+		for _, m := range []map[string]string{h.Props, h.Env, h.SSHConfig} {
+			if v, ok := m[key]; ok {
+				values = append(values, v)
+			}
+		}
+	}
+
+	return values
+}
+
 func (h *Host) DescriptionOrDefault() string {
 	if h.Description == "" {
 		return h.Name + " host"
@@ -83,6 +172,12 @@ Host {{$host.Name}}{{range $ii, $param := $host.SortedSSHConfig}}{{range $k, $v
 {{end -}}`
 
 func GenHostsConfig(enabledHosts []*Host) ([]byte, error) {
+	for _, host := range enabledHosts {
+		if err := host.ValidateJump(); err != nil {
+			return nil, err
+		}
+	}
+
 	tmpl, err := template.New("T").Parse(hostsTemplate)
 	if err != nil {
 		return nil, err
@@ -139,12 +234,14 @@ func esshHost(L *lua.LState) int {
 		tb.ForEach(func(k, v lua.LValue) {
 			name, ok := toString(k)
 			if !ok {
-				panic(fmt.Sprintf("expected string of host's name but got '%v'\n", k))
+				addConfigError(L, &ConfigError{Resource: "host", Message: fmt.Sprintf("name must be a string but got %s.", describeLValue(k))})
+				return
 			}
 
 			config, ok := toLTable(v)
 			if !ok {
-				panic(fmt.Sprintf("expected table of host's config but got '%v'\n", v))
+				addConfigError(L, &ConfigError{Resource: "host", Name: name, Message: fmt.Sprintf("config must be a table but got %s.", describeLValue(v))})
+				return
 			}
 
 			h := registerHost(L, name)
@@ -170,21 +267,28 @@ func esshHost(L *lua.LState) int {
 
 			return 1
 		} else {
-			panic("host requires 1 or 2 arguments")
+			argumentsError(L, "host", "requires 1 or 2 arguments.")
 		}
 	} else {
-		panic(fmt.Sprintf("expected table or string but got '%v'\n", value))
+		argumentsError(L, "host", fmt.Sprintf("the first argument must be a table or string but got %s.", describeLValue(value)))
 	}
+
+	return 0
 }
 
 func registerHost(L *lua.LState, name string) *Host {
 	if debugFlag {
-		fmt.Printf("[essh debug] register host: %s\n", name)
+		fmt.Printf("[essh debug] register host: %s (%s)\n", name, luaSourcePosition(L))
 	}
 
 	h := NewHost()
 	h.Name = name
 	h.Registry = CurrentRegistry
+	h.Source = luaSourcePosition(L)
+
+	if CurrentNamespace != nil && CurrentNamespace.Hosts {
+		h.Name = NamespacedHostName(CurrentNamespace.Name, name)
+	}
 
 	if host := Hosts[h.Name]; host != nil {
 		// detect same name host
@@ -220,7 +324,8 @@ func updateHost(L *lua.LState, h *Host, key string, value lua.LValue) {
 			return
 		}
 
-		panic("SSH property must be string")
+		fieldError(L, "host", h.Name, h.Source, key, "a string (ssh_config)", value)
+		return
 	}
 
 	switch key {
@@ -231,18 +336,35 @@ func updateHost(L *lua.LState, h *Host, key string, value lua.LValue) {
 
 			propsTb.ForEach(func(propsKey lua.LValue, propsValue lua.LValue) {
 				propsKeyStr, ok := toString(propsKey)
-				if !ok {
-					L.RaiseError("props table's key must be a string: %v", propsKey)
-				}
-				propsValueStr, ok := toString(propsValue)
-				if !ok {
-					L.RaiseError("props table's value must be a string: %v", propsValue)
+				propsValueStr, ok2 := toString(propsValue)
+				if !ok || !ok2 {
+					fieldError(L, "host", h.Name, h.Source, key, "a table of strings", value)
+					return
 				}
 
 				h.Props[propsKeyStr] = propsValueStr
 			})
 		} else {
-			panic("invalid value of a host's field '" + key + "'.")
+			fieldError(L, "host", h.Name, h.Source, key, "a table of strings", value)
+		}
+	case "env":
+		if env, err := toEnv(value); err == nil {
+			h.Env = env
+		} else {
+			fieldErrorWithMessage(L, "host", h.Name, h.Source, key, err.Error())
+		}
+	case "sensitive":
+		if sensitiveSlice, ok := toSlice(value); ok {
+			h.Sensitive = []string{}
+			for _, sensitive := range sensitiveSlice {
+				if sensitiveStr, ok := sensitive.(string); ok {
+					h.Sensitive = append(h.Sensitive, sensitiveStr)
+				}
+			}
+		} else if sensitiveStr, ok := toString(value); ok {
+			h.Sensitive = []string{sensitiveStr}
+		} else {
+			fieldError(L, "host", h.Name, h.Source, key, "a string or an array of strings", value)
 		}
 	case "hooks_before_connect":
 		if tb, ok := toLTable(value); ok {
@@ -254,7 +376,7 @@ func updateHost(L *lua.LState, h *Host, key string, value lua.LValue) {
 
 			h.HooksBeforeConnect = hooks
 		} else {
-			panic("invalid value of a host's field '" + key + "'.")
+			fieldError(L, "host", h.Name, h.Source, key, "an array", value)
 		}
 	case "hooks_after_connect":
 		if tb, ok := toLTable(value); ok {
@@ -266,7 +388,7 @@ func updateHost(L *lua.LState, h *Host, key string, value lua.LValue) {
 
 			h.HooksAfterConnect = hooks
 		} else {
-			panic("invalid value of a host's field '" + key + "'.")
+			fieldError(L, "host", h.Name, h.Source, key, "an array", value)
 		}
 	case "hooks_after_disconnect":
 		if tb, ok := toLTable(value); ok {
@@ -278,20 +400,48 @@ func updateHost(L *lua.LState, h *Host, key string, value lua.LValue) {
 
 			h.HooksAfterDisconnect = hooks
 		} else {
-			panic("invalid value of a host's field '" + key + "'.")
+			fieldError(L, "host", h.Name, h.Source, key, "an array", value)
+		}
+	case "before_connect":
+		h.BeforeConnect = toHostBeforeConnect(L, h, value)
+	case "after_disconnect":
+		h.AfterDisconnect = toHostAfterDisconnect(L, h, value)
+	case "jump":
+		if jumpStr, ok := toString(value); ok {
+			h.Jump = []string{jumpStr}
+		} else if jumpSlice, ok := toSlice(value); ok {
+			h.Jump = []string{}
+			for _, jump := range jumpSlice {
+				if jumpStr, ok := jump.(string); ok {
+					h.Jump = append(h.Jump, jumpStr)
+				} else {
+					fieldError(L, "host", h.Name, h.Source, key, "a string or an array of host names", value)
+					return
+				}
+			}
+		} else {
+			fieldError(L, "host", h.Name, h.Source, key, "a string or an array of host names", value)
+		}
+	case "socks":
+		if socksStr, ok := toString(value); ok {
+			h.Socks = socksStr
+		} else if socksNum, ok := toFloat64(value); ok {
+			h.Socks = fmt.Sprintf("%d", int(socksNum))
+		} else {
+			fieldError(L, "host", h.Name, h.Source, key, "a string or a number", value)
 		}
 	case "description":
 		if descStr, ok := toString(value); ok {
 			h.Description = descStr
 		} else {
-			panic("invalid value of a host's field '" + key + "'.")
+			fieldError(L, "host", h.Name, h.Source, key, "a string", value)
 		}
 
 	case "hidden":
 		if hiddenBool, ok := toBool(value); ok {
 			h.Hidden = hiddenBool
 		} else {
-			panic("invalid value of a host's field '" + key + "'.")
+			fieldError(L, "host", h.Name, h.Source, key, "a boolean", value)
 		}
 
 	case "tags":
@@ -303,15 +453,15 @@ func updateHost(L *lua.LState, h *Host, key string, value lua.LValue) {
 				if vs, ok := toString(v); ok {
 					h.Tags = append(h.Tags, vs)
 				} else {
-					L.RaiseError("unsupported format of tags.")
+					fieldError(L, "host", h.Name, h.Source, key, "an array of strings", value)
 				}
 			})
 		} else {
-			panic("invalid value of a host's field '" + key + "'.")
+			fieldError(L, "host", h.Name, h.Source, key, "an array of strings", value)
 		}
 
 	default:
-		panic("unsupported host's field '" + key + "'.")
+		fieldError(L, "host", h.Name, h.Source, key, "", value)
 
 	}
 }
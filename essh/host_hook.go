@@ -0,0 +1,119 @@
+package essh
+
+import (
+	"fmt"
+	"github.com/yuin/gopher-lua"
+	"time"
+)
+
+// ConnectResult is a result of a connection to a host that is passed to the host's after_disconnect function.
+type ConnectResult struct {
+	ExitCode int
+	Duration time.Duration
+	Error    error
+}
+
+func newLConnectResult(L *lua.LState, r *ConnectResult) *lua.LTable {
+	tb := L.NewTable()
+	tb.RawSetString("exit_code", lua.LNumber(r.ExitCode))
+	tb.RawSetString("duration", lua.LNumber(r.Duration.Seconds()))
+	if r.Error != nil {
+		tb.RawSetString("error", lua.LString(r.Error.Error()))
+	}
+
+	return tb
+}
+
+// toHostBeforeConnect converts a Lua function to a hook that runs in the essh process before connecting.
+// The function receives the Host object. If it returns false, the connection is canceled.
+func toHostBeforeConnect(L *lua.LState, host *Host, value lua.LValue) func() error {
+	if value == lua.LNil {
+		return nil
+	}
+
+	fn, ok := value.(*lua.LFunction)
+	if !ok {
+		fieldError(L, "host", host.Name, host.Source, "before_connect", "a function", value)
+		return nil
+	}
+
+	return func() error {
+		err := L.CallByParam(lua.P{
+			Fn:      fn,
+			NRet:    1,
+			Protect: true,
+		}, newLHost(L, host))
+		if err != nil {
+			return fmt.Errorf("before_connect of the host '%s' failed: %v", host.Name, err)
+		}
+
+		ret := L.Get(-1) // returned value
+		L.Pop(1)
+
+		if retB, ok := ret.(lua.LBool); ok && !bool(retB) {
+			return fmt.Errorf("returned false from the before_connect of the host '%s'.", host.Name)
+		}
+
+		return nil
+	}
+}
+
+// toHostAfterDisconnect converts a Lua function to a hook that runs in the essh process after disconnecting.
+// The function receives the Host object and the connection result.
+func toHostAfterDisconnect(L *lua.LState, host *Host, value lua.LValue) func(result *ConnectResult) error {
+	if value == lua.LNil {
+		return nil
+	}
+
+	fn, ok := value.(*lua.LFunction)
+	if !ok {
+		fieldError(L, "host", host.Name, host.Source, "after_disconnect", "a function", value)
+		return nil
+	}
+
+	return func(result *ConnectResult) error {
+		err := L.CallByParam(lua.P{
+			Fn:      fn,
+			NRet:    0,
+			Protect: true,
+		}, newLHost(L, host), newLConnectResult(L, result))
+		if err != nil {
+			return fmt.Errorf("after_disconnect of the host '%s' failed: %v", host.Name, err)
+		}
+
+		return nil
+	}
+}
+
+// runAfterDisconnectHooks runs the host's after_disconnect hooks and after_disconnect function with the result.
+// Each of them runs even if the other fails or panics, and the errors are returned.
+func runAfterDisconnectHooks(L *lua.LState, host *Host, result *ConnectResult) []error {
+	errs := []error{}
+
+	if err := protectHostHook(func() error {
+		return runHostHook(L, "after_disconnect", host.HooksAfterDisconnect)
+	}); err != nil {
+		errs = append(errs, fmt.Errorf("after_disconnect hook of the host '%s' failed: %v", host.Name, err))
+	}
+
+	if host.AfterDisconnect != nil {
+		if err := protectHostHook(func() error {
+			return host.AfterDisconnect(result)
+		}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// protectHostHook runs the hook and converts a panic in it to an error.
+func protectHostHook(hook func() error) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("%v", e)
+		}
+	}()
+
+	return hook()
+}
@@ -0,0 +1,130 @@
+package essh
+
+import (
+	"github.com/yuin/gopher-lua"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAfterDisconnectHooks(t *testing.T) {
+	cases := []struct {
+		name      string
+		hooks     string
+		function  string
+		shellRuns int
+		results   map[string]string
+		err       string
+	}{
+		{
+			name:      "all connected hosts",
+			hooks:     `{ "echo bye" }`,
+			function:  `function(host, r) results[host:name()] = tostring(r.exit_code) end`,
+			shellRuns: 2,
+			results:   map[string]string{"web01": "1", "web02": "1"},
+			err:       "fake failure on the host 'web01'",
+		},
+		{
+			name:      "failed function",
+			hooks:     `{ "echo bye" }`,
+			function:  `function(host, r) results[host:name()] = "called"; error("function failed") end`,
+			shellRuns: 2,
+			results:   map[string]string{"web01": "called", "web02": "called"},
+			err:       "fake failure on the host 'web01'",
+		},
+		{
+			name:      "panicked hook",
+			hooks:     `{ function() error("hook failed") end }`,
+			function:  `function(host, r) results[host:name()] = "called" end`,
+			shellRuns: 0,
+			results:   map[string]string{"web01": "called", "web02": "called"},
+			err:       "fake failure on the host 'web01'",
+		},
+	}
+
+	for _, c := range cases {
+		L := newTestLState()
+		HistoryFile = filepath.Join(os.TempDir(), "essh-test", "history")
+
+		code := `
+results = {}
+host "web01" { HostName = "192.168.0.11", hooks_after_disconnect = ` + c.hooks + `, after_disconnect = ` + c.function + ` }
+host "web02" { HostName = "192.168.0.12", hooks_after_disconnect = ` + c.hooks + `, after_disconnect = ` + c.function + ` }
+task "deploy" { backend = "remote", targets = { "web01", "web02" }, host_hooks = true, script = "echo" }
+`
+		if err := L.DoString(code); err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.name, err)
+		}
+
+		backend := NewFakeBackend()
+		backend.FailHosts["web01"] = true
+		CurrentFakeBackend = backend
+
+		err := runTask(filepath.Join(os.TempDir(), "essh-test", "ssh_config"), Tasks["deploy"], []string{}, L)
+		CurrentFakeBackend = nil
+
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: expected error %q but got %v", c.name, c.err, err)
+		}
+
+		shellRuns := 0
+		for _, hook := range backend.Hooks {
+			if hook.Name == "after_disconnect" {
+				shellRuns++
+			}
+		}
+		if shellRuns != c.shellRuns {
+			t.Errorf("%s: expected %d after_disconnect hooks but got %d", c.name, c.shellRuns, shellRuns)
+		}
+
+		results := L.GetGlobal("results").(*lua.LTable)
+		for name, expected := range c.results {
+			if actual := results.RawGetString(name).String(); actual != expected {
+				t.Errorf("%s: expected the result of '%s' to be %q but got %q", c.name, name, expected, actual)
+			}
+		}
+
+		L.Close()
+	}
+}
+
+func TestRunSSHReturnsAfterDisconnectErrors(t *testing.T) {
+	// ssh is not found in the empty PATH, so runSSH fails without connecting to anywhere.
+	dir, err := ioutil.TempDir("", "essh-host-hook-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir)
+	defer os.Setenv("PATH", path)
+
+	L := newTestLState()
+	defer L.Close()
+
+	AuditLogFile = ""
+	if err := L.DoString(`
+called = 0
+host "web01" { HostName = "192.168.0.11", after_disconnect = function(host, r) called = called + 1; error("function failed") end }
+`); err != nil {
+		t.Fatal(err)
+	}
+	LoadingConfig = false
+
+	for i := 0; i < 2; i++ {
+		err, ex := runSSH(L, filepath.Join(dir, "ssh_config"), []string{"web01"})
+		if err == nil || !strings.Contains(err.Error(), "function failed") {
+			t.Errorf("expected the error of the after_disconnect function but got %v", err)
+		}
+		if ex != ExitErr {
+			t.Errorf("expected the exit status %d but got %d", ExitErr, ex)
+		}
+	}
+
+	if called := L.GetGlobal("called").String(); called != "2" {
+		t.Errorf("expected the function to be called 2 times but got %s", called)
+	}
+}
@@ -0,0 +1,192 @@
+package essh
+
+import (
+	"fmt"
+	"github.com/yuin/gopher-lua"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Namespace is a prefix of the names of the resources that are defined in an included config file.
+type Namespace struct {
+	Name string
+	// Hosts is true if the host names are also prefixed.
+	Hosts bool
+}
+
+// CurrentNamespace is the namespace of the config file that is being included. It is nil in the other config files.
+var CurrentNamespace *Namespace
+
+// NamespacedTaskName returns a task name like "infra:deploy".
+func NamespacedTaskName(namespace string, name string) string {
+	if namespace == "" {
+		return name
+	}
+
+	return namespace + ":" + name
+}
+
+// NamespacedHostName returns a host name like "infra.web01".
+// It does not use ":", because ":" separates a host and a path in scp and rsync.
+func NamespacedHostName(namespace string, name string) string {
+	if namespace == "" {
+		return name
+	}
+
+	return strings.Replace(namespace, ":", ".", -1) + "." + name
+}
+
+// GetNamespaces returns the namespaces of the enabled and visible tasks.
+func GetNamespaces(tasks map[string]*Task) []string {
+	namespaces := []string{}
+	found := map[string]bool{}
+	for _, task := range tasks {
+		if task.Namespace == "" || task.Disabled || task.Hidden || found[task.Namespace] {
+			continue
+		}
+
+		found[task.Namespace] = true
+		namespaces = append(namespaces, task.Namespace)
+	}
+	sort.Strings(namespaces)
+
+	return namespaces
+}
+
+func esshInclude(L *lua.LState) int {
+	path := L.CheckString(1)
+	options := L.OptTable(2, L.NewTable())
+
+	namespace := ""
+	if v := options.RawGetString("namespace"); v != lua.LNil {
+		nsStr, ok := toString(v)
+		if !ok || nsStr == "" || strings.ContainsAny(nsStr, ": \t") {
+			L.ArgError(2, fmt.Sprintf("'namespace' must be a non-empty string without ':' and spaces but got %s.", describeLValue(v)))
+		}
+		namespace = nsStr
+	}
+
+	hosts := false
+	if v := options.RawGetString("hosts"); v != lua.LNil {
+		hostsBool, ok := toBool(v)
+		if !ok {
+			L.ArgError(2, fmt.Sprintf("'hosts' must be a boolean but got %s.", describeLValue(v)))
+		}
+		hosts = hostsBool
+	}
+
+	// a relative path is resolved from the directory of the config file that includes it.
+	if !filepath.IsAbs(path) {
+		if pos := luaSourcePosition(L); strings.Contains(pos, ":") {
+			path = filepath.Join(filepath.Dir(pos[:strings.LastIndex(pos, ":")]), path)
+		}
+	}
+
+	if len(configFiles(path)) == 0 {
+		L.RaiseError("couldn't find the config file '%s'.", path)
+	}
+
+	for _, file := range configFiles(path) {
+		for _, loading := range LoadingConfigFiles {
+			if file == loading {
+				L.RaiseError("'%s' is included recursively.", file)
+			}
+		}
+	}
+
+	parent := CurrentNamespace
+	if namespace != "" {
+		ns := &Namespace{Name: namespace, Hosts: hosts}
+		if parent != nil {
+			// nested namespaces like "infra:db"
+			ns.Name = NamespacedTaskName(parent.Name, namespace)
+			ns.Hosts = hosts || parent.Hosts
+		}
+		CurrentNamespace = ns
+	}
+	defer func() {
+		CurrentNamespace = parent
+	}()
+
+	if debugFlag {
+		fmt.Printf("[essh debug] include config file: %s (namespace: %s)\n", path, namespace)
+	}
+
+	defined := newDefinedResources()
+
+	if err := loadConfigFiles(L, path); err != nil {
+		if apiErr, ok := err.(*lua.ApiError); ok {
+			// raise the original error that has the position in the included file.
+			L.Error(apiErr.Object, 0)
+		}
+		L.RaiseError("%v", err)
+	}
+
+	if namespace != "" && CurrentNamespace.Hosts {
+		resolveNamespacedHosts(CurrentNamespace, defined)
+	}
+
+	return 0
+}
+
+// definedResources are the resources that have been defined before including a config file.
+type definedResources struct {
+	hosts   map[*Host]bool
+	tasks   map[*Task]bool
+	tunnels map[*Tunnel]bool
+}
+
+func newDefinedResources() *definedResources {
+	defined := &definedResources{
+		hosts:   map[*Host]bool{},
+		tasks:   map[*Task]bool{},
+		tunnels: map[*Tunnel]bool{},
+	}
+	for _, h := range Hosts {
+		defined.hosts[h] = true
+	}
+	for _, t := range Tasks {
+		defined.tasks[t] = true
+	}
+	for _, t := range Tunnels {
+		defined.tunnels[t] = true
+	}
+
+	return defined
+}
+
+// resolveNamespacedHosts rewrites the host names that the resources in the included config file refer to
+// (task targets and filters, host jump and tunnel host) to the namespaced names.
+// A name that is not defined in the namespace is kept, because it may refer to a host outside the included config file.
+func resolveNamespacedHosts(ns *Namespace, defined *definedResources) {
+	resolve := func(names []string) []string {
+		resolved := []string{}
+		for _, name := range names {
+			if namespaced := NamespacedHostName(ns.Name, name); Hosts[namespaced] != nil {
+				name = namespaced
+			}
+			resolved = append(resolved, name)
+		}
+		return resolved
+	}
+
+	for _, h := range Hosts {
+		if !defined.hosts[h] {
+			h.Jump = resolve(h.Jump)
+		}
+	}
+
+	for _, t := range Tasks {
+		if !defined.tasks[t] {
+			t.Targets = resolve(t.Targets)
+			t.Filters = resolve(t.Filters)
+		}
+	}
+
+	for _, t := range Tunnels {
+		if !defined.tunnels[t] && t.Host != "" {
+			t.Host = resolve([]string{t.Host})[0]
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package essh
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIncludeNamespaces(t *testing.T) {
+	root, err := ioutil.TempDir("", "essh-include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	writeTestFiles(t, root, map[string]string{
+		".esshconfig.lua": `
+host "bastion" {}
+essh.include("infra/essh.lua", { namespace = "infra", hosts = true })
+essh.include("app/essh.lua", { namespace = "app" })
+task "deploy" { targets = "web01" }
+`,
+		"infra/essh.lua": `
+host "web01" { jump = "gw" }
+host "gw" { jump = "bastion" }
+task "deploy" { targets = { "web01", "bastion" }, filters = "web01" }
+tunnel "infra-db" { host = "gw", local_port = 15432, remote = "db:5432" }
+essh.include("net/essh.lua", { namespace = "net" })
+`,
+		"infra/net/essh.lua": `
+host "router" { jump = "gw" }
+task "ping" { targets = "router" }
+`,
+		"app/essh.lua": `
+host "web01" {}
+task "deploy" { targets = "web01" }
+`,
+	})
+
+	L := newTestLState()
+	defer L.Close()
+	if err := loadConfigFiles(L, filepath.Join(root, ".esshconfig.lua")); err != nil {
+		t.Fatal(err)
+	}
+	if len(CollectedConfigErrors) > 0 {
+		t.Fatal(CollectedConfigErrors)
+	}
+
+	taskCases := []struct {
+		name      string
+		namespace string
+		targets   []string
+		filters   []string
+	}{
+		{name: "deploy", targets: []string{"web01"}, filters: []string{}},
+		// the references in the namespace are resolved to the namespaced hosts. the others refer to the outer hosts.
+		{name: "infra:deploy", namespace: "infra", targets: []string{"infra.web01", "bastion"}, filters: []string{"infra.web01"}},
+		{name: "infra:net:ping", namespace: "infra:net", targets: []string{"infra.net.router"}, filters: []string{}},
+		// the host names are not prefixed without the hosts option.
+		{name: "app:deploy", namespace: "app", targets: []string{"web01"}, filters: []string{}},
+	}
+	for _, c := range taskCases {
+		task := Tasks[c.name]
+		if task == nil {
+			t.Errorf("task '%s' is not defined", c.name)
+			continue
+		}
+		if task.Namespace != c.namespace || !reflect.DeepEqual(task.Targets, c.targets) || !reflect.DeepEqual(task.Filters, c.filters) {
+			t.Errorf("task '%s': unexpected namespace %q, targets %v and filters %v", c.name, task.Namespace, task.Targets, task.Filters)
+		}
+	}
+
+	hostCases := map[string][]string{
+		"bastion":          {},
+		"web01":            {},
+		"infra.web01":      {"infra.gw"},
+		"infra.gw":         {"bastion"},
+		"infra.net.router": {"infra.gw"},
+	}
+	for name, jump := range hostCases {
+		host := Hosts[name]
+		if host == nil {
+			t.Errorf("host '%s' is not defined", name)
+			continue
+		}
+		if !reflect.DeepEqual(host.Jump, jump) {
+			t.Errorf("host '%s': expected jump %v but got %v", name, jump, host.Jump)
+		}
+	}
+
+	if tunnel := Tunnels["infra-db"]; tunnel == nil || tunnel.Host != "infra.gw" {
+		t.Errorf("unexpected tunnel %v", tunnel)
+	}
+
+	if namespaces := GetNamespaces(Tasks); !reflect.DeepEqual(namespaces, []string{"app", "infra", "infra:net"}) {
+		t.Errorf("unexpected namespaces %v", namespaces)
+	}
+}
+
+func TestIncludeErrors(t *testing.T) {
+	cases := []struct {
+		name  string
+		files map[string]string
+		err   string
+	}{
+		{
+			name:  "recursive",
+			files: map[string]string{".esshconfig.lua": `essh.include("a.lua")`, "a.lua": `essh.include(".esshconfig.lua")`},
+			err:   "is included recursively",
+		},
+		{
+			name:  "not found",
+			files: map[string]string{".esshconfig.lua": `essh.include("none.lua")`},
+			err:   "couldn't find the config file",
+		},
+		{
+			name:  "invalid namespace",
+			files: map[string]string{".esshconfig.lua": `essh.include("a.lua", { namespace = "a:b" })`, "a.lua": ``},
+			err:   "'namespace' must be a non-empty string",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "essh-include")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+			writeTestFiles(t, root, c.files)
+
+			L := newTestLState()
+			defer L.Close()
+			err = loadConfigFiles(L, filepath.Join(root, ".esshconfig.lua"))
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("expected error containing %q but got %v", c.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package essh
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/kohkimakimoto/essh/support/knownhosts"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// KnownHostsEnabled enables the known_hosts management by essh.
+var KnownHostsEnabled bool
+
+// KnownHostsFile is a path to the known_hosts file that is used by all hosts.
+// If it is empty, each registry uses its own known_hosts file.
+var KnownHostsFile string
+
+// KnownHostsCheck enables checking the host keys before running the remote tasks.
+var KnownHostsCheck bool
+
+// HostKeyScanConcurrency is the max number of the hosts that are scanned at the same time.
+var HostKeyScanConcurrency = 10
+
+// checkedHostKeys caches the hosts whose keys have been checked in this process.
+// The key is the known_hosts file and the pattern of the host.
+var checkedHostKeys = map[string]bool{}
+
+// HostKeyScanner collects the public keys of the ssh server.
+// It is replaceable to check the host keys without the real servers.
+var HostKeyScanner = sshKeyscan
+
+// KnownHostsFile returns a path to the known_hosts file of the host. If the management is disabled, it returns an empty string.
+func (h *Host) KnownHostsFile() string {
+	if !KnownHostsEnabled {
+		return ""
+	}
+
+	if KnownHostsFile != "" {
+		return KnownHostsFile
+	}
+
+	if h.Registry == nil {
+		return ""
+	}
+
+	return h.Registry.KnownHostsFile()
+}
+
+// KnownHostsPattern returns the host name that ssh uses to look up the known_hosts.
+func (h *Host) KnownHostsPattern() string {
+	if alias := h.sshConfigValue("HostKeyAlias"); alias != "" {
+		return alias
+	}
+
+	return knownhosts.HostPattern(h.address(), h.sshConfigValue("Port"))
+}
+
+// IsProxied returns true if ssh connects to the host through a proxy (jump, ProxyJump or ProxyCommand).
+// The keys of such hosts can't be scanned directly from the local machine.
+func (h *Host) IsProxied() bool {
+	return len(h.Jump) > 0 || h.sshConfigValue("ProxyJump") != "" || h.sshConfigValue("ProxyCommand") != ""
+}
+
+func (h *Host) address() string {
+	if hostname := h.sshConfigValue("HostName"); hostname != "" {
+		return hostname
+	}
+
+	return h.Name
+}
+
+// sshConfigValue gets the value of the ssh_config. The keywords of ssh_config are case-insensitive.
+func (h *Host) sshConfigValue(key string) string {
+	for k, v := range h.SSHConfig {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+
+	return ""
+}
+
+func sshKeyscan(host *Host) ([]*knownhosts.Entry, error) {
+	args := []string{}
+	if port := host.sshConfigValue("Port"); port != "" {
+		args = append(args, "-p", port)
+	}
+	args = append(args, host.address())
+
+	cmd := exec.Command("ssh-keyscan", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if debugFlag {
+		fmt.Printf("[essh debug] real ssh-keyscan command: %v \n", cmd.Args)
+	}
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	keys := []*knownhosts.Entry{}
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		entry, err := knownhosts.ParseLine(line)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			keys = append(keys, entry)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("could not get any host keys of '%s'. %s", host.Name, strings.TrimSpace(stderr.String()))
+	}
+
+	return keys, nil
+}
+
+type hostKeys struct {
+	keys []*knownhosts.Entry
+	err  error
+}
+
+// scanAllHostKeys runs the HostKeyScanner with the hosts in parallel.
+// The number of the running scanners is limited by HostKeyScanConcurrency.
+func scanAllHostKeys(hosts []*Host) map[string]*hostKeys {
+	concurrency := HostKeyScanConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := map[string]*hostKeys{}
+	m := new(sync.Mutex)
+	sem := make(chan struct{}, concurrency)
+	wg := &sync.WaitGroup{}
+	for _, host := range hosts {
+		wg.Add(1)
+		go func(host *Host) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			keys, err := HostKeyScanner(host)
+			<-sem
+
+			m.Lock()
+			results[host.Name] = &hostKeys{keys: keys, err: err}
+			m.Unlock()
+		}(host)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func loadKnownHosts(path string) (*knownhosts.File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return knownhosts.Parse(strings.NewReader(""))
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	return knownhosts.Parse(f)
+}
+
+func saveKnownHosts(path string, f *knownhosts.File) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.FileMode(0755)); err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	if _, err := f.WriteTo(&b); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(path+".tmp", b.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// groupHostsByKnownHostsFile groups the hosts by their known_hosts files.
+func groupHostsByKnownHostsFile(hosts []*Host) ([]string, map[string][]*Host) {
+	paths := []string{}
+	groups := map[string][]*Host{}
+	for _, host := range hosts {
+		path := host.KnownHostsFile()
+		if path == "" {
+			continue
+		}
+		if _, ok := groups[path]; !ok {
+			paths = append(paths, path)
+		}
+		groups[path] = append(groups[path], host)
+	}
+	sort.Strings(paths)
+
+	return paths, groups
+}
+
+// scanHostKeys collects the host keys of the hosts and records them to the known_hosts files.
+func scanHostKeys(hosts []*Host) error {
+	if !KnownHostsEnabled {
+		return fmt.Errorf("--scan-host-keys requires 'essh.known_hosts' to be set.")
+	}
+
+	targets := []*Host{}
+	for _, host := range hosts {
+		if host.IsProxied() {
+			fmt.Fprintf(os.Stderr, "skipped scanning host keys of '%s': it is connected through a proxy. ssh checks its keys.\n", host.Name)
+			continue
+		}
+		targets = append(targets, host)
+	}
+
+	results := scanAllHostKeys(targets)
+
+	paths, groups := groupHostsByKnownHostsFile(targets)
+	failed := []string{}
+	for _, path := range paths {
+		f, err := loadKnownHosts(path)
+		if err != nil {
+			return err
+		}
+
+		for _, host := range groups[path] {
+			result := results[host.Name]
+			if result.err != nil {
+				fmt.Fprintf(os.Stderr, "failed to scan host keys of '%s': %v\n", host.Name, result.err)
+				failed = append(failed, host.Name)
+				continue
+			}
+
+			status := "new"
+			if err := f.Check(host.KnownHostsPattern(), result.keys); err == nil {
+				status = "unchanged"
+			} else if err == knownhosts.ErrChanged {
+				status = "changed"
+			}
+
+			f.Replace(host.KnownHostsPattern(), result.keys)
+			delete(checkedHostKeys, path+" "+host.KnownHostsPattern())
+			fmt.Printf("recorded %d host keys of '%s' (%s) to %s\n", len(result.keys), host.Name, status, path)
+		}
+
+		if err := saveKnownHosts(path, f); err != nil {
+			return err
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("could not scan host keys of the hosts: %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// checkHostKeys compares the current host keys with the recorded keys if KnownHostsCheck is enabled.
+// The hosts that do not have recorded keys, the proxied hosts and the hosts that have already been checked are not checked.
+func checkHostKeys(hosts []*Host) error {
+	if !KnownHostsCheck || CurrentFakeBackend != nil {
+		return nil
+	}
+
+	paths, groups := groupHostsByKnownHostsFile(hosts)
+
+	targets := []*Host{}
+	files := map[string]*knownhosts.File{}
+	for _, path := range paths {
+		f, err := loadKnownHosts(path)
+		if err != nil {
+			return err
+		}
+		files[path] = f
+
+		for _, host := range groups[path] {
+			if host.IsProxied() || checkedHostKeys[path+" "+host.KnownHostsPattern()] {
+				continue
+			}
+			if len(f.Lookup(host.KnownHostsPattern())) > 0 {
+				targets = append(targets, host)
+			}
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil
+	}
+
+	results := scanAllHostKeys(targets)
+
+	changed := []string{}
+	for _, host := range targets {
+		result := results[host.Name]
+		if result.err != nil {
+			// the hosts that can't be scanned are checked by ssh.
+			if debugFlag {
+				fmt.Printf("[essh debug] skip checking host keys of '%s': %v\n", host.Name, result.err)
+			}
+			continue
+		}
+
+		path := host.KnownHostsFile()
+		if err := files[path].Check(host.KnownHostsPattern(), result.keys); err == knownhosts.ErrChanged {
+			changed = append(changed, host.Name)
+		} else if err == nil {
+			checkedHostKeys[path+" "+host.KnownHostsPattern()] = true
+		}
+	}
+
+	if len(changed) > 0 {
+		return fmt.Errorf("host keys have changed: %s. if the changes are expected, record the new keys by 'essh --scan-host-keys --target <host>'.", strings.Join(changed, ", "))
+	}
+
+	return nil
+}
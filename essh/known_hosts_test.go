@@ -0,0 +1,251 @@
+package essh
+
+import (
+	"fmt"
+	"github.com/kohkimakimoto/essh/support/knownhosts"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newKnownHostsTestHost(name string, sshConfig map[string]string) *Host {
+	h := NewHost()
+	h.Name = name
+	for k, v := range sshConfig {
+		h.SSHConfig[k] = v
+	}
+
+	return h
+}
+
+// setupKnownHostsTest enables the known_hosts management with a temporary file.
+// It returns the path to the file and a function that removes the temporary directory.
+func setupKnownHostsTest(t *testing.T, content string) (string, func()) {
+	initResources()
+
+	dir, err := ioutil.TempDir("", "essh-known-hosts-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "known_hosts")
+	if content != "" {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	KnownHostsEnabled = true
+	KnownHostsFile = path
+
+	return path, func() {
+		HostKeyScanner = sshKeyscan
+		HostKeyScanConcurrency = 10
+		os.RemoveAll(dir)
+	}
+}
+
+// fakeHostKeyScanner returns the keys by the addresses of the hosts and records the scanned hosts.
+type fakeHostKeyScanner struct {
+	keys    map[string]string
+	scanned []string
+	m       sync.Mutex
+}
+
+func (s *fakeHostKeyScanner) Scan(host *Host) ([]*knownhosts.Entry, error) {
+	s.m.Lock()
+	s.scanned = append(s.scanned, host.Name)
+	s.m.Unlock()
+
+	key, ok := s.keys[host.address()]
+	if !ok {
+		return nil, fmt.Errorf("connection refused")
+	}
+
+	return []*knownhosts.Entry{{Hosts: []string{host.address()}, KeyType: "ssh-ed25519", Key: key}}, nil
+}
+
+func TestScanHostKeys(t *testing.T) {
+	path, cleanup := setupKnownHostsTest(t, "192.168.0.11 ssh-ed25519 AAAAold\nother.example.com ssh-rsa AAAAother\n")
+	defer cleanup()
+
+	scanner := &fakeHostKeyScanner{keys: map[string]string{
+		"192.168.0.11": "AAAAnew1",
+		"192.168.0.12": "AAAAnew2",
+		"192.168.0.13": "AAAAnew3",
+	}}
+	HostKeyScanner = scanner.Scan
+
+	web03 := newKnownHostsTestHost("web03", map[string]string{"HostName": "192.168.0.13"})
+	web03.Jump = []string{"bastion"}
+	hosts := []*Host{
+		newKnownHostsTestHost("web01", map[string]string{"HostName": "192.168.0.11"}),
+		newKnownHostsTestHost("web02", map[string]string{"HostName": "192.168.0.12", "Port": "2222"}),
+		web03,
+		newKnownHostsTestHost("web04", map[string]string{"HostName": "192.168.0.14"}),
+	}
+
+	err := scanHostKeys(hosts)
+	if err == nil || !strings.Contains(err.Error(), "could not scan host keys of the hosts: web04") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for _, name := range scanner.scanned {
+		if name == "web03" {
+			t.Errorf("the proxied host must not be scanned")
+		}
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "other.example.com ssh-rsa AAAAother\n192.168.0.11 ssh-ed25519 AAAAnew1\n[192.168.0.12]:2222 ssh-ed25519 AAAAnew2\n"
+	if string(b) != expected {
+		t.Errorf("expected known_hosts %q but got %q", expected, string(b))
+	}
+}
+
+func TestScanHostKeysConcurrency(t *testing.T) {
+	_, cleanup := setupKnownHostsTest(t, "")
+	defer cleanup()
+
+	HostKeyScanConcurrency = 2
+
+	var running, maxRunning int
+	m := new(sync.Mutex)
+	HostKeyScanner = func(host *Host) ([]*knownhosts.Entry, error) {
+		m.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		m.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		m.Lock()
+		running--
+		m.Unlock()
+
+		return []*knownhosts.Entry{{KeyType: "ssh-ed25519", Key: "AAAA" + host.Name}}, nil
+	}
+
+	hosts := []*Host{}
+	for i := 0; i < 6; i++ {
+		hosts = append(hosts, newKnownHostsTestHost(fmt.Sprintf("web%02d", i), nil))
+	}
+
+	if err := scanHostKeys(hosts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if maxRunning > 2 {
+		t.Errorf("expected at most 2 running scanners but got %d", maxRunning)
+	}
+}
+
+func TestCheckHostKeys(t *testing.T) {
+	recorded := "192.168.0.11 ssh-ed25519 AAAAkey1\n192.168.0.12 ssh-ed25519 AAAAkey2\n"
+
+	cases := []struct {
+		name    string
+		check   bool
+		keys    map[string]string
+		jump    bool
+		scanned []string
+		err     string
+	}{
+		{
+			name:    "unchanged",
+			check:   true,
+			keys:    map[string]string{"192.168.0.11": "AAAAkey1", "192.168.0.12": "AAAAkey2"},
+			scanned: []string{"web01", "web02"},
+		},
+		{
+			name:    "changed",
+			check:   true,
+			keys:    map[string]string{"192.168.0.11": "AAAAchanged", "192.168.0.12": "AAAAkey2"},
+			scanned: []string{"web01", "web02"},
+			err:     "host keys have changed: web01.",
+		},
+		{
+			name:    "not scanned hosts are left to ssh",
+			check:   true,
+			keys:    map[string]string{"192.168.0.12": "AAAAkey2"},
+			scanned: []string{"web01", "web02"},
+		},
+		{
+			name:    "disabled",
+			check:   false,
+			keys:    map[string]string{"192.168.0.11": "AAAAchanged", "192.168.0.12": "AAAAkey2"},
+			scanned: []string{},
+		},
+		{
+			name:    "proxied host",
+			check:   true,
+			keys:    map[string]string{"192.168.0.11": "AAAAchanged", "192.168.0.12": "AAAAkey2"},
+			jump:    true,
+			scanned: []string{"web02"},
+		},
+	}
+
+	for _, c := range cases {
+		_, cleanup := setupKnownHostsTest(t, recorded)
+
+		KnownHostsCheck = c.check
+		scanner := &fakeHostKeyScanner{keys: c.keys}
+		HostKeyScanner = scanner.Scan
+
+		web01 := newKnownHostsTestHost("web01", map[string]string{"HostName": "192.168.0.11"})
+		if c.jump {
+			web01.Jump = []string{"bastion"}
+		}
+		hosts := []*Host{
+			web01,
+			newKnownHostsTestHost("web02", map[string]string{"HostName": "192.168.0.12"}),
+			// the host that does not have recorded keys is not checked.
+			newKnownHostsTestHost("web03", map[string]string{"HostName": "192.168.0.13"}),
+		}
+
+		err := checkHostKeys(hosts)
+		cleanup()
+
+		if c.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+		} else if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: expected error %q but got %v", c.name, c.err, err)
+		}
+
+		sort.Strings(scanner.scanned)
+		if strings.Join(scanner.scanned, ",") != strings.Join(c.scanned, ",") {
+			t.Errorf("%s: expected scanned hosts %v but got %v", c.name, c.scanned, scanner.scanned)
+		}
+	}
+}
+
+func TestCheckHostKeysCached(t *testing.T) {
+	_, cleanup := setupKnownHostsTest(t, "192.168.0.11 ssh-ed25519 AAAAkey1\n")
+	defer cleanup()
+
+	KnownHostsCheck = true
+	scanner := &fakeHostKeyScanner{keys: map[string]string{"192.168.0.11": "AAAAkey1"}}
+	HostKeyScanner = scanner.Scan
+
+	hosts := []*Host{newKnownHostsTestHost("web01", map[string]string{"HostName": "192.168.0.11"})}
+	for i := 0; i < 3; i++ {
+		if err := checkHostKeys(hosts); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if len(scanner.scanned) != 1 {
+		t.Errorf("expected the host to be scanned once but got %v", scanner.scanned)
+	}
+}
@@ -0,0 +1,205 @@
+package essh
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LintProblem is a problem of the config that is reported by --lint.
+type LintProblem struct {
+	Source  string
+	Message string
+}
+
+func (p *LintProblem) String() string {
+	if p.Source == "" {
+		return p.Message
+	}
+
+	return p.Source + ": " + p.Message
+}
+
+// SSHConfigKeywords are the keywords of ssh_config(5). The keywords are compared case-insensitively.
+var SSHConfigKeywords = []string{
+	"AddKeysToAgent", "AddressFamily", "BatchMode", "BindAddress", "BindInterface",
+	"CanonicalDomains", "CanonicalizeFallbackLocal", "CanonicalizeHostname", "CanonicalizeMaxDots", "CanonicalizePermittedCNAMEs",
+	"CASignatureAlgorithms", "CertificateFile", "ChannelTimeout", "CheckHostIP", "Ciphers",
+	"ClearAllForwardings", "Compression", "ConnectionAttempts", "ConnectTimeout", "ControlMaster",
+	"ControlPath", "ControlPersist", "DynamicForward", "EnableEscapeCommandline", "EnableSSHKeysign",
+	"EscapeChar", "ExitOnForwardFailure", "FingerprintHash", "ForkAfterAuthentication", "ForwardAgent",
+	"ForwardX11", "ForwardX11Timeout", "ForwardX11Trusted", "GatewayPorts", "GlobalKnownHostsFile",
+	"GSSAPIAuthentication", "GSSAPIDelegateCredentials", "HashKnownHosts", "HostbasedAcceptedAlgorithms", "HostbasedAuthentication",
+	"HostbasedKeyTypes", "HostKeyAlgorithms", "HostKeyAlias", "HostName", "IdentitiesOnly",
+	"IdentityAgent", "IdentityFile", "IgnoreUnknown", "Include", "IPQoS",
+	"KbdInteractiveAuthentication", "KbdInteractiveDevices", "KexAlgorithms", "KnownHostsCommand", "LocalCommand",
+	"LocalForward", "LogLevel", "LogVerbose", "MACs", "NoHostAuthenticationForLocalhost",
+	"NumberOfPasswordPrompts", "ObscureKeystrokeTiming", "PasswordAuthentication", "PermitLocalCommand", "PermitRemoteOpen",
+	"PKCS11Provider", "Port", "PreferredAuthentications", "ProxyCommand", "ProxyJump",
+	"ProxyUseFdpass", "PubkeyAcceptedAlgorithms", "PubkeyAcceptedKeyTypes", "PubkeyAuthentication", "RekeyLimit",
+	"RemoteCommand", "RemoteForward", "RequestTTY", "RequiredRSASize", "RevokedHostKeys",
+	"SecurityKeyProvider", "SendEnv", "ServerAliveCountMax", "ServerAliveInterval", "SessionType",
+	"SetEnv", "StdinNull", "StreamLocalBindMask", "StreamLocalBindUnlink", "StrictHostKeyChecking",
+	"SyslogFacility", "Tag", "TCPKeepAlive", "Tunnel", "TunnelDevice",
+	"UpdateHostKeys", "User", "UserKnownHostsFile", "VerifyHostKeyDNS", "VisualHostKey",
+	"XAuthLocation",
+}
+
+func isSSHConfigKeyword(key string) bool {
+	for _, keyword := range SSHConfigKeywords {
+		if strings.EqualFold(keyword, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lintResources checks the loaded hosts, tasks and tunnels without running anything.
+func lintResources(tasks map[string]*Task, hosts map[string]*Host, tunnels map[string]*Tunnel) []*LintProblem {
+	problems := []*LintProblem{}
+	add := func(source string, format string, a ...interface{}) {
+		problems = append(problems, &LintProblem{Source: source, Message: fmt.Sprintf(format, a...)})
+	}
+
+	// problems that are found in loading the config files
+	for _, err := range CollectedConfigErrors {
+		e := *err
+		e.Source = ""
+		add(err.Source, "%s", e.Error())
+	}
+
+	// duplicated names of the hosts, tasks and tags
+	for _, task := range tasks {
+		if host, ok := hosts[task.PublicName()]; ok {
+			add(task.Source, "task '%s' is duplicated with the host defined in %s.", task.PublicName(), host.Source)
+		}
+	}
+	for _, tag := range GetTags(hosts) {
+		if host, ok := hosts[tag]; ok {
+			add(host.Source, "tag '%s' is duplicated with the hostname.", tag)
+		}
+	}
+
+	// hosts
+	for _, host := range hosts {
+		for key := range host.SSHConfig {
+			if !isSSHConfigKeyword(key) {
+				add(host.Source, "host '%s' has the unsupported ssh_config keyword '%s'.", host.Name, key)
+			}
+		}
+
+		if err := host.ValidateJump(); err != nil {
+			add(host.Source, "%v", err)
+		}
+	}
+
+	// tasks
+	referenced := map[string]bool{}
+	for _, task := range tasks {
+		if task.Disabled {
+			continue
+		}
+
+		if len(task.TargetsSlice()) > 0 {
+			matched := NewHostQuery().SetDatasource(hosts).
+				AppendSelections(task.TargetsSlice()).
+				AppendFilters(task.FiltersSlice()).
+				GetHosts()
+			if len(matched) == 0 {
+				add(task.Source, "targets of the task '%s' match no hosts.", task.Name)
+			}
+			for _, host := range matched {
+				referenced[host.Name] = true
+			}
+		} else if len(task.FiltersSlice()) > 0 {
+			add(task.Source, "task '%s' has 'filters' without 'targets'.", task.Name)
+		}
+
+		if task.Driver != "" && Drivers[task.Driver] == nil {
+			add(task.Source, "task '%s' uses the driver '%s' that is not defined.", task.Name, task.Driver)
+		}
+
+		if task.File != "" && !strings.HasPrefix(task.File, "http://") && !strings.HasPrefix(task.File, "https://") {
+			if _, err := os.Stat(task.File); err != nil {
+				add(task.Source, "script_file '%s' of the task '%s' does not exist.", task.File, task.Name)
+			}
+		}
+	}
+
+	// tunnels
+	for _, tunnel := range tunnels {
+		if err := tunnel.Validate(); err != nil {
+			add(tunnel.Source, "%v", err)
+		}
+		referenced[tunnel.Host] = true
+	}
+
+	// hidden hosts are usually used via the other hosts like gateways.
+	for _, host := range hosts {
+		for _, jump := range host.Jump {
+			referenced[jump] = true
+		}
+	}
+	for _, host := range hosts {
+		if !host.Hidden || referenced[host.Name] {
+			continue
+		}
+
+		used := false
+		for _, other := range hosts {
+			if other != host && sshConfigReferencesHost(other.SSHConfig, host.Name) {
+				used = true
+				break
+			}
+		}
+		if !used {
+			add(host.Source, "hidden host '%s' is not referenced by any tasks, hosts or tunnels.", host.Name)
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].Source != problems[j].Source {
+			return lessSource(problems[i].Source, problems[j].Source)
+		}
+		return problems[i].Message < problems[j].Message
+	})
+
+	return problems
+}
+
+// lessSource compares the sources like "file:line" by the file name and the line number.
+func lessSource(a string, b string) bool {
+	ai := strings.LastIndex(a, ":")
+	bi := strings.LastIndex(b, ":")
+	if ai >= 0 && bi >= 0 && a[:ai] == b[:bi] {
+		an, aerr := strconv.Atoi(a[ai+1:])
+		bn, berr := strconv.Atoi(b[bi+1:])
+		if aerr == nil && berr == nil {
+			return an < bn
+		}
+	}
+
+	return a < b
+}
+
+// sshConfigReferencesHost reports whether the ssh_config values (ex. ProxyCommand) use the host name.
+func sshConfigReferencesHost(sshConfig map[string]string, name string) bool {
+	for _, value := range sshConfig {
+		fields := strings.FieldsFunc(value, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ',' || r == '"' || r == '\'' || r == '='
+		})
+		for _, field := range fields {
+			if i := strings.LastIndex(field, "@"); i >= 0 {
+				field = field[i+1:]
+			}
+			if field == name || strings.HasPrefix(field, name+":") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
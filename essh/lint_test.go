@@ -0,0 +1,173 @@
+package essh
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLintResources(t *testing.T) {
+	cases := []struct {
+		name     string
+		code     string
+		problems []string
+	}{
+		{
+			name: "valid config",
+			code: `
+host "bastion" { HostName = "192.168.0.10", hidden = true }
+host "web01" { HostName = "192.168.0.11", jump = "bastion", tags = { "web" } }
+task "deploy" { targets = "web", script = "echo" }
+`,
+			problems: []string{},
+		},
+		{
+			name: "config errors",
+			code: `host "web01" { HostName = 1 }`,
+			problems: []string{
+				"host 'web01': 'HostName' must be a string (ssh_config) but got number 1.",
+			},
+		},
+		{
+			name: "duplicated names",
+			code: `
+host "web01" { HostName = "192.168.0.11", tags = { "web02" } }
+host "web02" { HostName = "192.168.0.12" }
+task "web01" { script = "echo" }
+`,
+			problems: []string{
+				"tag 'web02' is duplicated with the hostname.",
+				"task 'web01' is duplicated with the host defined in <string>:2.",
+			},
+		},
+		{
+			name: "hosts",
+			code: `
+host "web01" { HostName = "192.168.0.11", Hostnme = "typo" }
+host "web02" { HostName = "192.168.0.12", jump = "undefined" }
+`,
+			problems: []string{
+				"host 'web01' has the unsupported ssh_config keyword 'Hostnme'.",
+				"host 'web02' uses the jump host 'undefined' that is not defined (<string>:3).",
+			},
+		},
+		{
+			name: "tasks",
+			code: `
+task "deploy" { targets = "db", script = "echo" }
+task "build" { filters = "web", script = "echo" }
+task "release" { driver = "undefined", script = "echo" }
+task "disabled" { targets = "db", disabled = true, script = "echo" }
+`,
+			problems: []string{
+				"targets of the task 'deploy' match no hosts.",
+				"task 'build' has 'filters' without 'targets'.",
+				"task 'release' uses the driver 'undefined' that is not defined.",
+			},
+		},
+		{
+			name: "hidden hosts",
+			code: `
+host "gateway" { HostName = "192.168.0.1", hidden = true }
+host "bastion" { HostName = "192.168.0.10", hidden = true }
+host "unused" { HostName = "192.168.0.20", hidden = true }
+host "web01" { HostName = "192.168.0.11", ProxyCommand = "ssh -W %h:%p bastion" }
+host "db" { HostName = "192.168.0.30", hidden = true }
+tunnel "db" { host = "db", local_port = 5432, remote = "localhost:5432" }
+`,
+			problems: []string{
+				"hidden host 'gateway' is not referenced by any tasks, hosts or tunnels.",
+				"hidden host 'unused' is not referenced by any tasks, hosts or tunnels.",
+			},
+		},
+		{
+			name: "tunnels",
+			code: `
+host "db" { HostName = "192.168.0.30" }
+tunnel "db" { host = "undefined", local_port = 5432, remote = "localhost:5432" }
+tunnel "cache" { host = "db", remote = "localhost:6379" }
+`,
+			problems: []string{
+				"tunnel 'db' uses the host 'undefined' that is not defined.",
+				"tunnel 'cache' requires a valid 'local_port'.",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		L := newTestLState()
+		if err := L.DoString(c.code); err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.name, err)
+		}
+		L.Close()
+
+		messages := []string{}
+		for _, problem := range lintResources(Tasks, Hosts, Tunnels) {
+			messages = append(messages, problem.Message)
+		}
+
+		if !reflect.DeepEqual(messages, c.problems) {
+			t.Errorf("%s: expected %q but got %q", c.name, c.problems, messages)
+		}
+	}
+}
+
+func TestLessSource(t *testing.T) {
+	cases := []struct {
+		a    string
+		b    string
+		less bool
+	}{
+		{a: "esshconfig.lua:2", b: "esshconfig.lua:10", less: true},
+		{a: "esshconfig.lua:10", b: "esshconfig.lua:2", less: false},
+		{a: "a.lua:10", b: "b.lua:2", less: true},
+		{a: "hosts.yml", b: "hosts.yml:1", less: true},
+	}
+
+	for _, c := range cases {
+		if less := lessSource(c.a, c.b); less != c.less {
+			t.Errorf("lessSource(%q, %q): expected %v but got %v", c.a, c.b, c.less, less)
+		}
+	}
+}
+
+func TestLintScriptFileInParentDirectoryConfig(t *testing.T) {
+	root, err := ioutil.TempDir("", "essh-lint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	writeTestFiles(t, root, map[string]string{
+		".esshconfig.lua": `
+task "build" { script_file = "scripts/build.sh" }
+task "deploy" { script_file = "scripts/deploy.sh" }
+`,
+		"scripts/build.sh": "echo build",
+		"app/src/.keep":    "",
+	})
+
+	// the config in the parent directory is found from the subdirectory.
+	path := findWorkingDirConfigFile(filepath.Join(root, "app", "src"))
+
+	L := newTestLState()
+	defer L.Close()
+	if err := loadConfigFiles(L, path); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := []string{}
+	for _, problem := range lintResources(Tasks, Hosts, Tunnels) {
+		messages = append(messages, problem.Message)
+	}
+
+	expected := []string{
+		fmt.Sprintf("script_file '%s' of the task 'deploy' does not exist.", filepath.Join(root, "scripts", "deploy.sh")),
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("expected %q but got %q", expected, messages)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/yuin/gopher-lua"
 	gluajson "layeh.com/gopher-json"
 	"net/http"
+	"strings"
 )
 
 func InitLuaState(L *lua.LState) {
@@ -23,12 +24,15 @@ func InitLuaState(L *lua.LState) {
 	registerHostQueryClass(L)
 	registerRegistryClass(L)
 	registerGroupClass(L)
+	registerTunnelClass(L)
+	registerTestContextClass(L)
 
 	// global functions
 	L.SetGlobal("host", L.NewFunction(esshHost))
 	L.SetGlobal("task", L.NewFunction(esshTask))
 	L.SetGlobal("driver", L.NewFunction(esshDriver))
 	L.SetGlobal("group", L.NewFunction(esshGroup))
+	L.SetGlobal("tunnel", L.NewFunction(esshTunnel))
 
 	// modules
 	L.PreloadModule("json", gluajson.Loader)
@@ -40,6 +44,7 @@ func InitLuaState(L *lua.LState) {
 	L.PreloadModule("http", gluahttp.NewHttpModule(&http.Client{}).Loader)
 	L.PreloadModule("re", gluare.Loader)
 	L.PreloadModule("sh", gluash.Loader)
+	L.PreloadModule("vault", vaultLoader)
 
 	// global variables
 	lessh := L.NewTable()
@@ -54,18 +59,21 @@ func InitLuaState(L *lua.LState) {
 		"task":   esshTask,
 		"driver": esshDriver,
 		"group":  esshGroup,
+		"tunnel": esshTunnel,
 
 		// utility functions
 		"debug":            esshDebug,
 		"select_hosts":     esshSelectHosts,
 		"current_registry": esshCurrentRegistry,
+		"secret":           esshSecret,
+		"include":          esshInclude,
 	})
 }
 
 func esshDebug(L *lua.LState) int {
 	msg := L.CheckString(1)
 	if debugFlag {
-		fmt.Printf("[essh debug] %s\n", msg)
+		fmt.Printf("[essh debug] %s\n", Secrets.Mask(msg))
 	}
 
 	return 0
@@ -76,6 +84,50 @@ func esshCurrentRegistry(L *lua.LState) int {
 	return 1
 }
 
+// luaSourcePosition returns a position ("file:line") of the lua code that calls the current go function.
+// In loading a YAML or TOML config file, it returns the file path.
+func luaSourcePosition(L *lua.LState) string {
+	if CurrentDataConfigFile != "" {
+		return CurrentDataConfigFile
+	}
+
+	return strings.TrimSuffix(L.Where(1), ":")
+}
+
+// toEnv converts a lua table to environment variables that are exported by the exact names.
+func toEnv(value lua.LValue) (map[string]string, error) {
+	envTb, ok := toLTable(value)
+	if !ok {
+		return nil, fmt.Errorf("'env' must be a table of strings but got %s.", describeLValue(value))
+	}
+
+	env := map[string]string{}
+	var err error
+	envTb.ForEach(func(envKey lua.LValue, envValue lua.LValue) {
+		if err != nil {
+			return
+		}
+
+		envKeyStr, ok := toString(envKey)
+		if !ok || !IsValidEnvKey(envKeyStr) {
+			err = fmt.Errorf("'env' key must be a valid environment variable name but got %s.", describeLValue(envKey))
+			return
+		}
+		envValueStr, ok := toString(envValue)
+		if !ok {
+			err = fmt.Errorf("'env' value of '%s' must be a string but got %s.", envKeyStr, describeLValue(envValue))
+			return
+		}
+
+		env[envKeyStr] = envValueStr
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
 // This code inspired by https://github.com/yuin/gluamapper/blob/master/gluamapper.go
 func toGoValue(lv lua.LValue) interface{} {
 	switch v := lv.(type) {
@@ -108,6 +160,52 @@ func toGoValue(lv lua.LValue) interface{} {
 	}
 }
 
+// toLValue converts a go value that is decoded from JSON, YAML or TOML to a lua value.
+func toLValue(L *lua.LState, value interface{}) lua.LValue {
+	switch v := value.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(v)
+	case string:
+		return lua.LString(v)
+	case int:
+		return lua.LNumber(v)
+	case int64:
+		return lua.LNumber(v)
+	case uint64:
+		return lua.LNumber(v)
+	case float64:
+		return lua.LNumber(v)
+	case []interface{}:
+		tb := L.CreateTable(len(v), 0)
+		for _, item := range v {
+			tb.Append(toLValue(L, item))
+		}
+		return tb
+	case []map[string]interface{}:
+		tb := L.CreateTable(len(v), 0)
+		for _, item := range v {
+			tb.Append(toLValue(L, item))
+		}
+		return tb
+	case map[interface{}]interface{}:
+		tb := L.CreateTable(0, len(v))
+		for key, item := range v {
+			tb.RawSetString(fmt.Sprint(key), toLValue(L, item))
+		}
+		return tb
+	case map[string]interface{}:
+		tb := L.CreateTable(0, len(v))
+		for key, item := range v {
+			tb.RawSetString(key, toLValue(L, item))
+		}
+		return tb
+	default:
+		return lua.LString(fmt.Sprint(v))
+	}
+}
+
 func toBool(v lua.LValue) (bool, bool) {
 	if lv, ok := v.(lua.LBool); ok {
 		return bool(lv), true
@@ -0,0 +1,266 @@
+package essh
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/kohkimakimoto/essh/support/color"
+	"golang.org/x/crypto/ssh/terminal"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// PickerMaxLines is the max number of the hosts that are shown in the picker at once.
+var PickerMaxLines = 20
+
+// HostPicker is an interactive fuzzy-filterable list of the hosts.
+// It works in a plain terminal by using the raw mode of the terminal.
+// If the terminal is not available, it falls back to a line based selection.
+type HostPicker struct {
+	Hosts    []*Host
+	in       io.Reader
+	out      io.Writer
+	query    string
+	cursor   int
+	lines    int
+	maxLines int
+}
+
+func NewHostPicker(hosts []*Host) *HostPicker {
+	return &HostPicker{
+		Hosts:    hosts,
+		in:       os.Stdin,
+		out:      os.Stderr,
+		maxLines: PickerMaxLines,
+	}
+}
+
+// Pick returns the selected host. If the selection is canceled, it returns nil.
+func (p *HostPicker) Pick() (*Host, error) {
+	if len(p.Hosts) == 0 {
+		return nil, fmt.Errorf("there are not hosts to pick.")
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return p.pickByLine()
+	}
+
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return p.pickByLine()
+	}
+	defer terminal.Restore(fd, state)
+
+	if _, rows, err := terminal.GetSize(fd); err == nil && rows > 3 && rows-2 < p.maxLines {
+		p.maxLines = rows - 2
+	}
+
+	return p.pickByKey()
+}
+
+func (p *HostPicker) pickByKey() (*Host, error) {
+	reader := bufio.NewReader(p.in)
+	defer p.clear()
+
+	for {
+		matched := FilterHosts(p.Hosts, p.query)
+		if p.cursor >= len(matched) {
+			p.cursor = len(matched) - 1
+		}
+		if p.cursor < 0 {
+			p.cursor = 0
+		}
+		p.render(matched)
+
+		r, _, err := reader.ReadRune()
+		if err != nil {
+			return nil, err
+		}
+
+		switch r {
+		case 3, 4, 7: // Ctrl-C, Ctrl-D, Ctrl-G
+			return nil, nil
+		case 13, 10: // Enter
+			if len(matched) == 0 {
+				continue
+			}
+			return matched[p.cursor], nil
+		case 127, 8: // Backspace
+			if p.query != "" {
+				_, size := utf8.DecodeLastRuneInString(p.query)
+				p.query = p.query[:len(p.query)-size]
+			}
+		case 21: // Ctrl-U
+			p.query = ""
+		case 16: // Ctrl-P
+			p.cursor--
+		case 14: // Ctrl-N
+			p.cursor++
+		case 27: // ESC or arrow keys
+			if reader.Buffered() == 0 {
+				return nil, nil
+			}
+			seq := make([]byte, 2)
+			if _, err := io.ReadFull(reader, seq); err != nil {
+				return nil, err
+			}
+			switch string(seq) {
+			case "[A":
+				p.cursor--
+			case "[B":
+				p.cursor++
+			}
+		default:
+			if r >= 32 {
+				p.query += string(r)
+				p.cursor = 0
+			}
+		}
+	}
+}
+
+func (p *HostPicker) render(matched []*Host) {
+	p.clear()
+
+	fmt.Fprintf(p.out, "%s %s\r\n", color.FgCB(">"), p.query)
+	p.lines = 1
+
+	// scroll the list to show the cursor.
+	offset := 0
+	if p.cursor >= p.maxLines {
+		offset = p.cursor - p.maxLines + 1
+	}
+
+	for i := offset; i < len(matched) && i < offset+p.maxLines; i++ {
+		line := formatPickerLine(matched[i])
+		if i == p.cursor {
+			fmt.Fprintf(p.out, "%s %s\r\n", color.FgCB(">"), color.FgCB("%s", line))
+		} else {
+			fmt.Fprintf(p.out, "  %s\r\n", line)
+		}
+		p.lines++
+	}
+
+	fmt.Fprintf(p.out, "  %d/%d", len(matched), len(p.Hosts))
+}
+
+func (p *HostPicker) clear() {
+	if p.lines > 0 {
+		fmt.Fprintf(p.out, "\r\033[%dA\033[J", p.lines)
+	} else {
+		fmt.Fprint(p.out, "\r\033[J")
+	}
+	p.lines = 0
+}
+
+func (p *HostPicker) pickByLine() (*Host, error) {
+	scanner := bufio.NewScanner(p.in)
+	matched := p.Hosts
+
+	for {
+		for i, host := range matched {
+			fmt.Fprintf(p.out, "%3d) %s\n", i+1, formatPickerLine(host))
+		}
+		fmt.Fprint(p.out, "Select a host by the number, or enter a text to filter the hosts: ")
+
+		if !scanner.Scan() {
+			return nil, scanner.Err()
+		}
+
+		input := strings.TrimSpace(scanner.Text())
+		if input == "" {
+			return nil, nil
+		}
+
+		if n, err := strconv.Atoi(input); err == nil && n >= 1 && n <= len(matched) {
+			return matched[n-1], nil
+		}
+
+		filtered := FilterHosts(p.Hosts, input)
+		if len(filtered) == 1 {
+			return filtered[0], nil
+		}
+		if len(filtered) == 0 {
+			fmt.Fprintf(p.out, "There are not hosts that match '%s'.\n", input)
+			continue
+		}
+		matched = filtered
+	}
+}
+
+func formatPickerLine(host *Host) string {
+	line := host.Name
+	if host.Description != "" {
+		line += "  " + host.Description
+	}
+	if len(host.Tags) > 0 {
+		line += "  [" + strings.Join(host.Tags, ",") + "]"
+	}
+
+	return line
+}
+
+// FilterHosts returns the hosts that match the query by the fuzzy matching.
+// The hosts are ordered by the score. The names are prior to the descriptions and the tags.
+func FilterHosts(hosts []*Host, query string) []*Host {
+	if query == "" {
+		return hosts
+	}
+
+	type scored struct {
+		host  *Host
+		score int
+	}
+
+	results := []scored{}
+	for _, host := range hosts {
+		if score, ok := FuzzyMatch(query, host.Name); ok {
+			results = append(results, scored{host, score})
+		} else if score, ok := FuzzyMatch(query, formatPickerLine(host)); ok {
+			results = append(results, scored{host, score + 1000})
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].score < results[j].score
+	})
+
+	filtered := []*Host{}
+	for _, r := range results {
+		filtered = append(filtered, r.host)
+	}
+
+	return filtered
+}
+
+// FuzzyMatch reports whether the text contains the characters of the pattern in order (case-insensitive).
+// The score is lower when the matched characters are closer and earlier.
+func FuzzyMatch(pattern string, text string) (int, bool) {
+	pattern = strings.ToLower(pattern)
+	text = strings.ToLower(text)
+
+	if idx := strings.Index(text, pattern); idx >= 0 {
+		return idx, true
+	}
+
+	first := -1
+	last := -1
+	pos := 0
+	for _, r := range pattern {
+		idx := strings.IndexRune(text[pos:], r)
+		if idx < 0 {
+			return 0, false
+		}
+		if first < 0 {
+			first = pos + idx
+		}
+		last = pos + idx
+		pos += idx + utf8.RuneLen(r)
+	}
+
+	return 100 + (last - first) + first, true
+}
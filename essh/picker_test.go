@@ -0,0 +1,159 @@
+package essh
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newPickerTestHosts() []*Host {
+	hosts := []*Host{}
+	for _, h := range []struct {
+		name        string
+		description string
+		tags        []string
+	}{
+		{name: "db01", description: "primary database", tags: []string{"db"}},
+		{name: "web01", description: "web server", tags: []string{"web"}},
+		{name: "web02", description: "web server", tags: []string{"web", "canary"}},
+		{name: "worker01", description: "batch worker", tags: []string{"batch"}},
+	} {
+		host := NewHost()
+		host.Name = h.name
+		host.Description = h.description
+		host.Tags = h.tags
+		hosts = append(hosts, host)
+	}
+
+	return hosts
+}
+
+func pickerHostNames(hosts []*Host) []string {
+	names := []string{}
+	for _, host := range hosts {
+		names = append(names, host.Name)
+	}
+
+	return names
+}
+
+func TestFuzzyMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		text    string
+		score   int
+		ok      bool
+	}{
+		{pattern: "web", text: "web01", score: 0, ok: true},
+		{pattern: "01", text: "web01", score: 3, ok: true},
+		{pattern: "WEB", text: "web01", score: 0, ok: true},
+		{pattern: "w1", text: "web01", score: 104, ok: true},
+		{pattern: "wr1", text: "worker01", score: 107, ok: true},
+		{pattern: "1w", text: "web01", ok: false},
+		{pattern: "db", text: "web01", ok: false},
+		{pattern: "", text: "web01", score: 0, ok: true},
+	}
+
+	for _, c := range cases {
+		score, ok := FuzzyMatch(c.pattern, c.text)
+		if ok != c.ok || (ok && score != c.score) {
+			t.Errorf("FuzzyMatch(%q, %q): expected (%d, %v) but got (%d, %v)", c.pattern, c.text, c.score, c.ok, score, ok)
+		}
+	}
+}
+
+func TestFilterHosts(t *testing.T) {
+	hosts := newPickerTestHosts()
+
+	cases := []struct {
+		query    string
+		expected []string
+	}{
+		{query: "", expected: []string{"db01", "web01", "web02", "worker01"}},
+		{query: "web", expected: []string{"web01", "web02", "worker01"}},
+		{query: "w1", expected: []string{"web01", "worker01"}},
+		// the names are prior to the descriptions and the tags.
+		{query: "er", expected: []string{"worker01", "web01", "web02"}},
+		{query: "canary", expected: []string{"web02"}},
+		{query: "database", expected: []string{"db01"}},
+		{query: "nothing", expected: []string{}},
+	}
+
+	for _, c := range cases {
+		if names := pickerHostNames(FilterHosts(hosts, c.query)); !reflect.DeepEqual(names, c.expected) {
+			t.Errorf("FilterHosts(%q): expected %v but got %v", c.query, c.expected, names)
+		}
+	}
+}
+
+func TestHostPickerPickByKey(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{input: "\r", expected: "db01"},
+		{input: "web\r", expected: "web01"},
+		{input: "web\x0e\r", expected: "web02"},
+		{input: "\x1b[B\x1b[B\x1b[A\r", expected: "web01"},
+		{input: "wx\x7f02\r", expected: "web02"},
+		{input: "db\x15work\r", expected: "worker01"},
+		{input: "nothing\r\x03", expected: ""},
+		{input: "\x03", expected: ""},
+	}
+
+	for _, c := range cases {
+		var out bytes.Buffer
+		p := NewHostPicker(newPickerTestHosts())
+		p.in = strings.NewReader(c.input)
+		p.out = &out
+
+		host, err := p.pickByKey()
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.input, err)
+			continue
+		}
+
+		name := ""
+		if host != nil {
+			name = host.Name
+		}
+		if name != c.expected {
+			t.Errorf("%q: expected %q but got %q", c.input, c.expected, name)
+		}
+	}
+}
+
+func TestHostPickerPickByLine(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{input: "2\n", expected: "web01"},
+		{input: "canary\n", expected: "web02"},
+		{input: "web\n2\n", expected: "web02"},
+		{input: "nothing\n\n", expected: ""},
+		{input: "\n", expected: ""},
+	}
+
+	for _, c := range cases {
+		var out bytes.Buffer
+		p := NewHostPicker(newPickerTestHosts())
+		p.in = strings.NewReader(c.input)
+		p.out = &out
+
+		host, err := p.pickByLine()
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", c.input, err)
+			continue
+		}
+
+		name := ""
+		if host != nil {
+			name = host.Name
+		}
+		if name != c.expected {
+			t.Errorf("%q: expected %q but got %q", c.input, c.expected, name)
+		}
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"github.com/yuin/gopher-lua"
+	"path/filepath"
 )
 
 type Registry struct {
-	Key  string
-	Type int
+	Key     string
+	Type    int
+	DataDir string
 }
 
 const (
@@ -22,8 +24,9 @@ var LocalRegistry *Registry
 
 func NewRegistry(dataDir string, registryType int) *Registry {
 	reg := &Registry{
-		Key:  fmt.Sprintf("%x", sha256.Sum256([]byte(dataDir))),
-		Type: registryType,
+		Key:     fmt.Sprintf("%x", sha256.Sum256([]byte(dataDir))),
+		Type:    registryType,
+		DataDir: dataDir,
 	}
 
 	return reg
@@ -77,6 +80,14 @@ func NewRegistry(dataDir string, registryType int) *Registry {
 //	return nil
 //}
 
+func (reg *Registry) TunnelsDir() string {
+	return filepath.Join(reg.DataDir, "tunnels")
+}
+
+func (reg *Registry) KnownHostsFile() string {
+	return filepath.Join(reg.DataDir, "known_hosts")
+}
+
 func (reg *Registry) TypeString() string {
 	if reg.Type == RegistryTypeGlobal {
 		return "global"
@@ -0,0 +1,328 @@
+package essh
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/kohkimakimoto/essh/support/vault"
+	"github.com/yuin/gopher-lua"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// SecretProvider gets a secret value by the name.
+// The second returned value reports whether the provider has the secret.
+type SecretProvider interface {
+	GetSecret(name string) (string, bool, error)
+}
+
+// EnvSecretProvider gets a secret from an environment variable like "ESSH_SECRET_DB_PASSWORD".
+type EnvSecretProvider struct {
+	Prefix string
+}
+
+func (p *EnvSecretProvider) GetSecret(name string) (string, bool, error) {
+	value, ok := os.LookupEnv(p.Prefix + strings.ToUpper(EnvKeyEscape(name)))
+	return value, ok, nil
+}
+
+// FileSecretProvider gets a secret from a local JSON file that is encrypted by the vault.
+type FileSecretProvider struct {
+	Path    string
+	KeyEnv  string
+	KeyFile string
+	secrets map[string]string
+}
+
+func (p *FileSecretProvider) GetSecret(name string) (string, bool, error) {
+	if p.secrets == nil {
+		if _, err := os.Stat(p.Path); os.IsNotExist(err) {
+			return "", false, nil
+		}
+
+		b, err := ioutil.ReadFile(p.Path)
+		if err != nil {
+			return "", false, err
+		}
+
+		if vault.IsEncrypted(string(b)) {
+			key, err := LoadVaultKey(p.KeyEnv, p.KeyFile)
+			if err != nil {
+				return "", false, err
+			}
+
+			b, err = vault.Decrypt(key, string(b))
+			if err != nil {
+				return "", false, fmt.Errorf("%v: %s", err, p.Path)
+			}
+		}
+
+		secrets := map[string]string{}
+		if err := json.Unmarshal(b, &secrets); err != nil {
+			return "", false, fmt.Errorf("secrets file must be a JSON object that has string values: %s: %v", p.Path, err)
+		}
+		p.secrets = secrets
+	}
+
+	value, ok := p.secrets[name]
+	return value, ok, nil
+}
+
+// CommandSecretProvider gets a secret from the output of an external command like "pass show {{.Name}}".
+// The name in the command is escaped for the shell. RawName is the name as it is.
+type CommandSecretProvider struct {
+	Command string
+}
+
+func (p *CommandSecretProvider) GetSecret(name string) (string, bool, error) {
+	funcMap := template.FuncMap{
+		"ShellEscape": ShellEscape,
+	}
+
+	tmpl, err := template.New("T").Funcs(funcMap).Parse(p.Command)
+	if err != nil {
+		return "", false, err
+	}
+
+	var command bytes.Buffer
+	if err := tmpl.Execute(&command, map[string]interface{}{"Name": ShellEscape(name), "RawName": name}); err != nil {
+		return "", false, err
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.Command("bash", "-c", command.String())
+	cmd.Env = append(os.Environ(), "ESSH_SECRET_NAME="+name)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", false, fmt.Errorf("failed to get the secret '%s' by the command: %v", name, err)
+	}
+
+	return strings.TrimRight(stdout.String(), "\r\n"), true, nil
+}
+
+const DefaultSecretEnvPrefix = "ESSH_SECRET_"
+
+type SecretStore struct {
+	Providers []SecretProvider
+	values    map[string]string
+	mutex     *sync.RWMutex
+	// providersTb is the 'essh.secret_providers' table that the Providers are built from.
+	providersTb *lua.LTable
+}
+
+var Secrets *SecretStore
+
+var SecretMask = "********"
+
+func NewSecretStore() *SecretStore {
+	return &SecretStore{
+		Providers: []SecretProvider{
+			&EnvSecretProvider{Prefix: DefaultSecretEnvPrefix},
+		},
+		values: map[string]string{},
+		mutex:  new(sync.RWMutex),
+	}
+}
+
+func (s *SecretStore) Get(name string) (string, error) {
+	s.mutex.RLock()
+	value, ok := s.values[name]
+	s.mutex.RUnlock()
+	if ok {
+		return value, nil
+	}
+
+	for _, provider := range s.Providers {
+		value, ok, err := provider.GetSecret(name)
+		if err != nil {
+			return "", err
+		}
+
+		if ok {
+			s.Add(name, value)
+			return value, nil
+		}
+	}
+
+	return "", fmt.Errorf("secret '%s' is not found.", name)
+}
+
+// Add registers a secret value. The registered values are masked in outputs.
+func (s *SecretStore) Add(name string, value string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.values[name] = value
+}
+
+// maskedValues returns the secret values that are masked in the order of length.
+// All values are masked even if they are short, because they are declared as secrets.
+// Only an empty value is skipped.
+func (s *SecretStore) maskedValues() []string {
+	s.mutex.RLock()
+	values := []string{}
+	for _, value := range s.values {
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	s.mutex.RUnlock()
+
+	// replace longer values at first.
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+
+	return values
+}
+
+// Contains reports whether the string has the secret values that are masked.
+func (s *SecretStore) Contains(str string) bool {
+	if s == nil {
+		return false
+	}
+
+	for _, value := range s.maskedValues() {
+		if strings.Contains(str, value) || strings.Contains(str, strings.Trim(ShellEscape(value), "'")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Mask replaces the secret values in the string with the SecretMask.
+func (s *SecretStore) Mask(str string) string {
+	if s == nil {
+		return str
+	}
+
+	for _, value := range s.maskedValues() {
+		str = strings.Replace(str, value, SecretMask, -1)
+		// secrets in a script are passed to the shell with escaping.
+		if escaped := strings.Trim(ShellEscape(value), "'"); escaped != value {
+			str = strings.Replace(str, escaped, SecretMask, -1)
+		}
+	}
+
+	return str
+}
+
+// AddSensitiveValues registers the values that are declared as sensitive in the hosts and tasks.
+func (s *SecretStore) AddSensitiveValues(hosts map[string]*Host, tasks map[string]*Task) {
+	for _, host := range hosts {
+		for i, value := range host.SensitiveValues() {
+			s.Add(fmt.Sprintf("host:%s:%d", host.Name, i), value)
+		}
+	}
+
+	for _, task := range tasks {
+		for i, value := range task.SensitiveValues() {
+			s.Add(fmt.Sprintf("task:%s:%d", task.Name, i), value)
+		}
+	}
+}
+
+// LoadVaultKey loads a passphrase from the environment variable or the key file.
+// The encryption key is derived from the passphrase and the salt of each encrypted data.
+func LoadVaultKey(keyEnv string, keyFile string) ([]byte, error) {
+	if keyEnv == "" {
+		keyEnv = "ESSH_VAULT_KEY"
+	}
+
+	if passphrase := os.Getenv(keyEnv); passphrase != "" {
+		return []byte(passphrase), nil
+	}
+
+	if keyFile == "" {
+		keyFile = os.Getenv("ESSH_VAULT_KEY_FILE")
+	}
+	if keyFile == "" {
+		keyFile = filepath.Join(UserDataDir, "vault.key")
+	}
+
+	b, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load the vault key. set the environment variable '%s' or create the key file: %v", keyEnv, err)
+	}
+
+	return bytes.TrimSpace(b), nil
+}
+
+func esshSecret(L *lua.LState) int {
+	name := L.CheckString(1)
+
+	lessh, ok := toLTable(L.GetGlobal("essh"))
+	if !ok {
+		L.RaiseError("essh must be a table")
+	}
+
+	// the providers are built once for the table, so that the file provider keeps the loaded secrets.
+	if providersTb, ok := toLTable(lessh.RawGetString("secret_providers")); ok && providersTb != Secrets.providersTb {
+		Secrets.Providers = toSecretProviders(L, providersTb)
+		Secrets.providersTb = providersTb
+	}
+
+	value, err := Secrets.Get(name)
+	if err != nil {
+		L.RaiseError("%v", err)
+	}
+
+	L.Push(lua.LString(value))
+	return 1
+}
+
+func toSecretProviders(L *lua.LState, providersTb *lua.LTable) []SecretProvider {
+	providers := []SecretProvider{}
+
+	maxn := providersTb.MaxN()
+	for i := 1; i <= maxn; i++ {
+		config, ok := toMap(providersTb.RawGetInt(i))
+		if !ok {
+			L.RaiseError("secret provider must be a table.")
+		}
+
+		getString := func(key string) string {
+			if v, ok := config[key].(string); ok {
+				return v
+			}
+			return ""
+		}
+
+		switch getString("type") {
+		case "env":
+			prefix := DefaultSecretEnvPrefix
+			if _, ok := config["prefix"]; ok {
+				prefix = getString("prefix")
+			}
+			providers = append(providers, &EnvSecretProvider{Prefix: prefix})
+		case "file":
+			path := getString("path")
+			if path == "" {
+				L.RaiseError("secret provider 'file' requires 'path'.")
+			}
+			providers = append(providers, &FileSecretProvider{
+				Path:    path,
+				KeyEnv:  getString("key_env"),
+				KeyFile: getString("key_file"),
+			})
+		case "command":
+			command := getString("command")
+			if command == "" {
+				L.RaiseError("secret provider 'command' requires 'command'.")
+			}
+			providers = append(providers, &CommandSecretProvider{Command: command})
+		default:
+			L.RaiseError("unsupported secret provider type '%s'. it must be 'env', 'file' or 'command'.", getString("type"))
+		}
+	}
+
+	return providers
+}
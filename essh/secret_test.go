@@ -0,0 +1,99 @@
+package essh
+
+import (
+	"github.com/yuin/gopher-lua"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecretStoreMask(t *testing.T) {
+	s := NewSecretStore()
+	s.Add("password", "p@ssw0rd")
+	s.Add("token", "p@ssw0rd-token")
+	s.Add("quote", "it's secret")
+	s.Add("short", "yes")
+	s.Add("empty", "")
+
+	cases := []struct {
+		str      string
+		expected string
+		contains bool
+	}{
+		{str: "password=p@ssw0rd", expected: "password=" + SecretMask, contains: true},
+		{str: "token=p@ssw0rd-token", expected: "token=" + SecretMask, contains: true},
+		{str: "echo 'it'\"'\"'s secret'", expected: "echo '" + SecretMask + "'", contains: true},
+		{str: "yes, it is", expected: SecretMask + ", it is", contains: true},
+		{str: "nothing", expected: "nothing", contains: false},
+	}
+
+	for _, c := range cases {
+		if masked := s.Mask(c.str); masked != c.expected {
+			t.Errorf("Mask(%q): expected %q but got %q", c.str, c.expected, masked)
+		}
+
+		if contains := s.Contains(c.str); contains != c.contains {
+			t.Errorf("Contains(%q): expected %v but got %v", c.str, c.contains, contains)
+		}
+	}
+}
+
+func TestCommandSecretProviderEscapesName(t *testing.T) {
+	cases := []struct {
+		command  string
+		name     string
+		expected string
+	}{
+		{command: "echo {{.Name}}", name: "db password; exit 1", expected: "db password; exit 1"},
+		{command: "echo {{.Name}}", name: "it's", expected: "it's"},
+		{command: "echo prefix-{{.RawName}}", name: "db_password", expected: "prefix-db_password"},
+	}
+
+	for _, c := range cases {
+		value, ok, err := (&CommandSecretProvider{Command: c.command}).GetSecret(c.name)
+		if err != nil || !ok {
+			t.Errorf("%q: unexpected result %v, %v", c.name, ok, err)
+			continue
+		}
+		if value != c.expected {
+			t.Errorf("%q: expected %q but got %q", c.name, c.expected, value)
+		}
+	}
+}
+
+func TestSecretProvidersAreBuiltOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "essh-secret-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "secrets.json")
+	if err := ioutil.WriteFile(path, []byte(`{"a": "secret-a", "b": "secret-b"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	L := newTestLState()
+	defer L.Close()
+
+	L.SetGlobal("path", lua.LString(path))
+	if err := L.DoString(`
+essh.secret_providers = { { type = "file", path = path } }
+a = essh.secret("a")
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file provider has loaded the secrets, so the removed file is not read again.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := L.DoString(`b = essh.secret("b")`); err != nil {
+		t.Fatal(err)
+	}
+
+	if a, b := L.GetGlobal("a").String(), L.GetGlobal("b").String(); a != "secret-a" || b != "secret-b" {
+		t.Errorf("unexpected secrets %q and %q", a, b)
+	}
+}
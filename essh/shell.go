@@ -0,0 +1,245 @@
+package essh
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/kohkimakimoto/essh/support/color"
+	"github.com/kohkimakimoto/essh/support/helper"
+	"github.com/yuin/gopher-lua"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+)
+
+// Shell is an interactive prompt that runs the entered commands on the hosts.
+type Shell struct {
+	Config    string
+	Task      *Task
+	Hosts     []*Host
+	Fold      bool
+	LastCodes map[string]int
+	L         *lua.LState
+	out       io.Writer
+}
+
+var ShellHelp = `Enter a command to run it on the hosts. The following commands are handled by essh:
+
+  :hosts                 List the current hosts.
+  :add <tag|host>...     Add the hosts.
+  :remove <tag|host>...  Remove the hosts.
+  :prefix                Toggle the prefix of the outputs.
+  :fold                  Toggle the fold mode that groups the same outputs of the hosts.
+  :status                Show the last exit codes of the hosts.
+  :help                  Show this help.
+  :exit                  Exit the shell. (or Ctrl-D)
+`
+
+func NewShell(config string, task *Task, hosts []*Host, L *lua.LState) *Shell {
+	return &Shell{
+		Config:    config,
+		Task:      task,
+		Hosts:     hosts,
+		LastCodes: map[string]int{},
+		L:         L,
+		out:       os.Stdout,
+	}
+}
+
+func (sh *Shell) Prompt() string {
+	return fmt.Sprintf("essh(%d hosts)> ", len(sh.Hosts))
+}
+
+// Run reads commands from the reader until EOF or ':exit'.
+func (sh *Shell) Run(in io.Reader) error {
+	// Ctrl-C interrupts the running commands but does not exit the shell.
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	defer signal.Stop(sigCh)
+	go func() {
+		for range sigCh {
+			fmt.Fprintln(sh.out)
+		}
+	}()
+
+	scanner := bufio.NewScanner(in)
+	fmt.Fprint(sh.out, sh.Prompt())
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == ":exit" || line == ":quit" {
+			return nil
+		}
+
+		if err := sh.Handle(line); err != nil {
+			fmt.Fprint(os.Stderr, color.FgRB("essh error: %v\n", Secrets.Mask(err.Error())))
+		}
+		fmt.Fprint(sh.out, sh.Prompt())
+	}
+	fmt.Fprintln(sh.out)
+
+	return scanner.Err()
+}
+
+func (sh *Shell) Handle(line string) error {
+	if line == "" {
+		return nil
+	}
+
+	if !strings.HasPrefix(line, ":") {
+		return sh.Exec(line)
+	}
+
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case ":hosts":
+		for _, host := range sh.Hosts {
+			fmt.Fprintf(sh.out, "%s\n", host.Name)
+		}
+	case ":add":
+		if len(fields) < 2 {
+			return fmt.Errorf(":add requires tags or hosts.")
+		}
+		hosts := NewHostQuery().AppendSelections(fields[1:]).GetHostsOrderByName()
+		if len(hosts) == 0 {
+			return fmt.Errorf("there are not hosts that match '%s'.", strings.Join(fields[1:], " "))
+		}
+		sh.AddHosts(hosts)
+	case ":remove":
+		if len(fields) < 2 {
+			return fmt.Errorf(":remove requires tags or hosts.")
+		}
+		sh.RemoveHosts(NewHostQuery().AppendSelections(fields[1:]).GetHostsOrderByName())
+	case ":prefix":
+		sh.Task.UsePrefix = !sh.Task.UsePrefix
+		fmt.Fprintf(sh.out, "prefix: %s\n", helper.ConditionString(sh.Task.UsePrefix, "on", "off"))
+	case ":fold":
+		sh.Fold = !sh.Fold
+		fmt.Fprintf(sh.out, "fold: %s\n", helper.ConditionString(sh.Fold, "on", "off"))
+	case ":status":
+		tb := helper.NewPlainTable(sh.out)
+		tb.SetHeader([]string{"HOST", "EXIT"})
+		for _, host := range sh.Hosts {
+			code := "-"
+			if c, ok := sh.LastCodes[host.Name]; ok {
+				code = fmt.Sprintf("%d", c)
+			}
+			tb.Append([]string{host.Name, code})
+		}
+		tb.Render()
+	case ":help":
+		fmt.Fprint(sh.out, ShellHelp)
+	default:
+		return fmt.Errorf("unknown command '%s'. see ':help'.", fields[0])
+	}
+
+	return nil
+}
+
+func (sh *Shell) AddHosts(hosts []*Host) {
+	for _, host := range hosts {
+		if !sh.hasHost(host.Name) {
+			sh.Hosts = append(sh.Hosts, host)
+		}
+	}
+
+	sort.Slice(sh.Hosts, func(i, j int) bool {
+		return sh.Hosts[i].Name < sh.Hosts[j].Name
+	})
+}
+
+func (sh *Shell) RemoveHosts(hosts []*Host) {
+	removed := map[string]bool{}
+	for _, host := range hosts {
+		removed[host.Name] = true
+	}
+
+	remaining := []*Host{}
+	for _, host := range sh.Hosts {
+		if !removed[host.Name] {
+			remaining = append(remaining, host)
+		}
+	}
+	sh.Hosts = remaining
+}
+
+func (sh *Shell) hasHost(name string) bool {
+	for _, host := range sh.Hosts {
+		if host.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Exec runs the command on the hosts like '--exec --parallel --prefix'.
+// The command goes through the same path as runTask, so that the known hosts check, hooks, audit log and history work as usual.
+func (sh *Shell) Exec(command string) error {
+	if len(sh.Hosts) == 0 {
+		return fmt.Errorf("there are not hosts. add hosts by ':add <tag|host>'.")
+	}
+
+	sh.Task.Script = []map[string]string{
+		map[string]string{"code": command},
+	}
+
+	// the command runs with the current hosts of the shell.
+	sh.Task.FixedHosts = []string{}
+	for _, host := range sh.Hosts {
+		sh.Task.FixedHosts = append(sh.Task.FixedHosts, host.Name)
+	}
+
+	runner := func(config string, task *Task, hosts []*Host, result *TaskResult) error {
+		var err error
+		if sh.Fold {
+			outputs := map[string]*bytes.Buffer{}
+			for _, host := range hosts {
+				outputs[host.Name] = new(bytes.Buffer)
+			}
+
+			usePrefix := task.UsePrefix
+			task.UsePrefix = false
+			err = runTaskScriptsWithOutputs(config, task, hosts, result, func(host *Host) io.Writer {
+				return outputs[host.Name]
+			})
+			task.UsePrefix = usePrefix
+
+			sh.printFolded(hosts, outputs)
+		} else {
+			err = runTaskScriptsWithOutputs(config, task, hosts, result, func(host *Host) io.Writer {
+				return sh.out
+			})
+		}
+
+		for name, code := range result.ExitCodes() {
+			sh.LastCodes[name] = code
+		}
+
+		return err
+	}
+
+	return runTaskWithScriptsRunner(sh.Config, sh.Task, []string{}, sh.L, runner, true)
+}
+
+// printFolded prints the same outputs of the hosts together.
+func (sh *Shell) printFolded(hosts []*Host, outputs map[string]*bytes.Buffer) {
+	groups := []string{}
+	groupHosts := map[string][]string{}
+	for _, host := range hosts {
+		output := outputs[host.Name].String()
+		if _, ok := groupHosts[output]; !ok {
+			groups = append(groups, output)
+		}
+		groupHosts[output] = append(groupHosts[output], host.Name)
+	}
+
+	for _, output := range groups {
+		fmt.Fprintf(sh.out, "%s\n", color.FgCB("[%s]", strings.Join(groupHosts[output], ",")))
+		fmt.Fprint(sh.out, output)
+		if output != "" && !strings.HasSuffix(output, "\n") {
+			fmt.Fprintln(sh.out)
+		}
+	}
+}
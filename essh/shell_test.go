@@ -0,0 +1,211 @@
+package essh
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newShellTestShell(t *testing.T, targets ...string) (*Shell, *bytes.Buffer) {
+	task := NewTask()
+	task.Name = ExecTaskName
+	task.Backend = TASK_BACKEND_REMOTE
+	task.Parallel = true
+	task.UsePrefix = true
+	task.Targets = targets
+
+	hosts, err := getTaskHosts(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	sh := NewShell(filepath.Join(os.TempDir(), "essh-test", "ssh_config"), task, hosts, nil)
+	sh.out = &out
+
+	return sh, &out
+}
+
+func shellHostNames(sh *Shell) []string {
+	names := []string{}
+	for _, host := range sh.Hosts {
+		names = append(names, host.Name)
+	}
+
+	return names
+}
+
+func TestShellHandle(t *testing.T) {
+	L := newTestLState()
+	defer L.Close()
+
+	if err := L.DoString(`
+host "web01" { HostName = "192.168.0.11", tags = { "web" } }
+host "web02" { HostName = "192.168.0.12", tags = { "web" } }
+host "db01" { HostName = "192.168.0.21", tags = { "db" } }
+`); err != nil {
+		t.Fatal(err)
+	}
+
+	sh, out := newShellTestShell(t, "web01")
+	sh.L = L
+
+	cases := []struct {
+		line   string
+		hosts  []string
+		output string
+		err    string
+	}{
+		{line: "", hosts: []string{"web01"}},
+		{line: ":hosts", hosts: []string{"web01"}, output: "web01\n"},
+		{line: ":add db web", hosts: []string{"db01", "web01", "web02"}},
+		{line: ":add", hosts: []string{"db01", "web01", "web02"}, err: ":add requires tags or hosts."},
+		{line: ":add undefined", hosts: []string{"db01", "web01", "web02"}, err: "there are not hosts that match 'undefined'."},
+		{line: ":remove web", hosts: []string{"db01"}},
+		{line: ":remove", hosts: []string{"db01"}, err: ":remove requires tags or hosts."},
+		{line: ":prefix", hosts: []string{"db01"}, output: "prefix: off\n"},
+		{line: ":fold", hosts: []string{"db01"}, output: "fold: on\n"},
+		{line: ":unknown", hosts: []string{"db01"}, err: "unknown command ':unknown'. see ':help'."},
+		{line: ":remove db01", hosts: []string{}},
+		{line: "uptime", hosts: []string{}, err: "there are not hosts. add hosts by ':add <tag|host>'."},
+	}
+
+	for _, c := range cases {
+		out.Reset()
+		err := sh.Handle(c.line)
+		if c.err == "" && err != nil {
+			t.Errorf("%q: unexpected error: %v", c.line, err)
+		}
+		if c.err != "" && (err == nil || err.Error() != c.err) {
+			t.Errorf("%q: expected error %q but got %v", c.line, c.err, err)
+		}
+		if names := shellHostNames(sh); !reflect.DeepEqual(names, c.hosts) {
+			t.Errorf("%q: expected the hosts %v but got %v", c.line, c.hosts, names)
+		}
+		if out.String() != c.output {
+			t.Errorf("%q: expected the output %q but got %q", c.line, c.output, out.String())
+		}
+	}
+}
+
+func TestShellAddAndRemoveHosts(t *testing.T) {
+	initResources()
+
+	hosts := map[string]*Host{}
+	for _, name := range []string{"web01", "web02", "web03"} {
+		hosts[name] = NewHost()
+		hosts[name].Name = name
+	}
+
+	sh := NewShell("", NewTask(), []*Host{hosts["web02"]}, nil)
+
+	sh.AddHosts([]*Host{hosts["web03"], hosts["web01"], hosts["web02"]})
+	if names := shellHostNames(sh); !reflect.DeepEqual(names, []string{"web01", "web02", "web03"}) {
+		t.Errorf("expected the sorted hosts without duplicates but got %v", names)
+	}
+
+	sh.RemoveHosts([]*Host{hosts["web02"]})
+	if names := shellHostNames(sh); !reflect.DeepEqual(names, []string{"web01", "web03"}) {
+		t.Errorf("expected 'web02' to be removed but got %v", names)
+	}
+
+	// removing the host that is not in the shell does nothing.
+	sh.RemoveHosts([]*Host{hosts["web02"]})
+	if names := shellHostNames(sh); !reflect.DeepEqual(names, []string{"web01", "web03"}) {
+		t.Errorf("expected the hosts not to be changed but got %v", names)
+	}
+}
+
+func TestShellExec(t *testing.T) {
+	dir, err := ioutil.TempDir("", "essh-shell-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	L := newTestLState()
+	defer L.Close()
+
+	AuditLogFile = filepath.Join(dir, "audit.log")
+	HistoryFile = filepath.Join(dir, "history")
+	defer func() {
+		AuditLogFile = ""
+		HistoryFile = ""
+	}()
+
+	if err := L.DoString(`
+host "web01" { HostName = "192.168.0.11", tags = { "web" }, hooks_before_connect = { "echo before" } }
+host "web02" { HostName = "192.168.0.12", tags = { "web" } }
+host "db01" { HostName = "192.168.0.21", tags = { "db" } }
+`); err != nil {
+		t.Fatal(err)
+	}
+	LoadingConfig = false
+
+	backend := NewFakeBackend()
+	backend.FailHosts["web02"] = true
+	CurrentFakeBackend = backend
+	defer func() {
+		CurrentFakeBackend = nil
+	}()
+
+	sh, _ := newShellTestShell(t, "web")
+	sh.L = L
+	sh.Task.HostHooks = true
+
+	err = sh.Exec("uptime")
+	if err == nil || !strings.Contains(err.Error(), "web02") {
+		t.Errorf("expected the error of 'web02' but got %v", err)
+	}
+
+	executed := []string{}
+	for _, execution := range backend.Executions {
+		executed = append(executed, execution.Host)
+		if !strings.Contains(execution.Script, "uptime") {
+			t.Errorf("expected the script to run 'uptime' but got %q", execution.Script)
+		}
+	}
+	if len(executed) != 2 {
+		t.Errorf("expected the command to run on 2 hosts but got %v", executed)
+	}
+	if len(backend.Hooks) != 1 || backend.Hooks[0].Name != "before_connect" {
+		t.Errorf("expected the before_connect hook of 'web01' to run but got %v", backend.Hooks)
+	}
+	if !reflect.DeepEqual(sh.LastCodes, map[string]int{"web01": 0, "web02": 1}) {
+		t.Errorf("unexpected last exit codes %v", sh.LastCodes)
+	}
+
+	// the hosts added in the shell are used by the next command.
+	if err := sh.Handle(":add db01"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sh.Handle(":remove web02"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sh.Exec("hostname"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	records, err := LoadHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 history records but got %d", len(records))
+	}
+	if !reflect.DeepEqual(records[1].Hosts, []string{"db01", "web01"}) || records[1].Command != "hostname" {
+		t.Errorf("unexpected history record %+v", records[1])
+	}
+
+	b, err := ioutil.ReadFile(AuditLogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(b), "\n"); lines != 2 {
+		t.Errorf("expected 2 audit records but got %d", lines)
+	}
+}
@@ -3,41 +3,64 @@ package essh
 import (
 	"fmt"
 	"github.com/yuin/gopher-lua"
+	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 type Task struct {
-	Name        string
-	Description string
-	Props       map[string]string
-	Prepare     func() error
-	Driver      string
-	Pty         bool
-	Script      []map[string]string
-	File        string
-	Backend     string
-	Targets     []string
-	Filters     []string
-	Parallel    bool
-	Privileged  bool
-	User        string
-	SSHOptions  []string
+	Name            string
+	Description     string
+	LongDescription string
+	Props           map[string]string
+	Env             map[string]string
+	Sensitive       []string
+	Prepare         func() error
+	Before          func(result *TaskResult) error
+	After           func(result *TaskResult) error
+	OnSuccess       func(result *TaskResult) error
+	OnFailure       func(result *TaskResult) error
+	Driver          string
+	Pty             bool
+	Script          []map[string]string
+	File            string
+	Backend         string
+	Targets         []string
+	Filters         []string
+	Parallel        bool
+	Privileged      bool
+	User            string
+	SSHOptions      []string
+	// HostHooks fires the hosts' before_connect and after_disconnect hooks around the remote task execution.
+	HostHooks bool
 	// deprecated? use only hidden?
 	Disabled  bool
 	Hidden    bool
 	Prefix    string
 	UsePrefix bool
 	Registry  *Registry
+	// Namespace is the namespace of the included config file that defines the task. The Name has it as a prefix.
+	Namespace string
 	Group     *Group
 	Args      []string
-	LValues   map[string]lua.LValue
-	Parent    *Task
-	Child     *Task
+	Source    string
+	Params    map[string]*TaskParam
+	// ParamValues stores parsed values of the Params from the command line arguments.
+	ParamValues map[string]string
+	// FixedHosts are host names that are used instead of the targets and filters. (by --rerun option)
+	FixedHosts []string
+	LValues    map[string]lua.LValue
+	Parent     *Task
+	Child      *Task
 }
 
 var Tasks map[string]*Task
 
 var DefaultTaskName = "default"
 
+// ExecTaskName is a name of the temporary task that runs commands by --exec option.
+var ExecTaskName = "--exec"
+
 var (
 	DefaultPrefixLocal  = `[local:{{.Host.Name}}]{{HostnameAlignString " "}}`
 	DefaultPrefixRemote = `[remote:{{.Host.Name}}]{{HostnameAlignString " "}}`
@@ -50,13 +73,17 @@ const (
 
 func NewTask() *Task {
 	return &Task{
-		Targets: []string{},
-		Filters: []string{},
-		Backend: TASK_BACKEND_LOCAL,
-		SSHOptions: []string{},
-		Script:  []map[string]string{},
-		Args:    []string{},
-		LValues: map[string]lua.LValue{},
+		Targets:     []string{},
+		Filters:     []string{},
+		Backend:     TASK_BACKEND_LOCAL,
+		SSHOptions:  []string{},
+		Script:      []map[string]string{},
+		Args:        []string{},
+		Env:         map[string]string{},
+		Sensitive:   []string{},
+		Params:      map[string]*TaskParam{},
+		ParamValues: map[string]string{},
+		LValues:     map[string]lua.LValue{},
 	}
 }
 
@@ -94,6 +121,20 @@ func (t *Task) FiltersSlice() []string {
 	return []string{}
 }
 
+// SensitiveValues returns the values of the props, env and params that are declared as sensitive.
+func (t *Task) SensitiveValues() []string {
+	values := []string{}
+	for _, key := range t.Sensitive {
+		for _, m := range []map[string]string{t.Props, t.Env, t.ParamValues} {
+			if v, ok := m[key]; ok {
+				values = append(values, v)
+			}
+		}
+	}
+
+	return values
+}
+
 func (t *Task) DescriptionOrDefault() string {
 	if t.Description == "" {
 		return t.Name + " task"
@@ -130,17 +171,25 @@ func esshTask(L *lua.LState) int {
 		return 1
 	}
 
-	panic("task requires 1 or 2 arguments")
+	argumentsError(L, "task", "requires 1 or 2 arguments.")
+
+	return 0
 }
 
 func registerTask(L *lua.LState, name string) *Task {
 	if debugFlag {
-		fmt.Printf("[essh debug] register task: %s\n", name)
+		fmt.Printf("[essh debug] register task: %s (%s)\n", name, luaSourcePosition(L))
 	}
 
 	t := NewTask()
 	t.Name = name
 	t.Registry = CurrentRegistry
+	t.Source = luaSourcePosition(L)
+
+	if CurrentNamespace != nil {
+		t.Namespace = CurrentNamespace.Name
+		t.Name = NamespacedTaskName(t.Namespace, name)
+	}
 
 	if task := Tasks[t.Name]; task != nil {
 		// detect same name task
@@ -242,11 +291,10 @@ func updateTask(L *lua.LState, task *Task, key string, value lua.LValue) {
 
 	switch key {
 	case "backend":
-		if backendStr, ok := toString(value); ok {
+		if backendStr, ok := toString(value); ok && (backendStr == TASK_BACKEND_LOCAL || backendStr == TASK_BACKEND_REMOTE) {
 			task.Backend = backendStr
-			if backendStr != TASK_BACKEND_LOCAL && backendStr != TASK_BACKEND_REMOTE {
-				L.RaiseError("backend must be '%s' or '%s'.", TASK_BACKEND_LOCAL, TASK_BACKEND_REMOTE)
-			}
+		} else {
+			fieldError(L, "task", task.Name, task.Source, key, fmt.Sprintf("'%s' or '%s'", TASK_BACKEND_LOCAL, TASK_BACKEND_REMOTE), value)
 		}
 	case "targets":
 		if targetsStr, ok := toString(value); ok {
@@ -260,7 +308,7 @@ func updateTask(L *lua.LState, task *Task, key string, value lua.LValue) {
 				}
 			}
 		} else {
-			panic("invalid value of a task's field '" + key + "'.")
+			fieldError(L, "task", task.Name, task.Source, key, "a string or an array of strings", value)
 		}
 	case "filters":
 		if filtersStr, ok := toString(value); ok {
@@ -274,43 +322,49 @@ func updateTask(L *lua.LState, task *Task, key string, value lua.LValue) {
 				}
 			}
 		} else {
-			panic("invalid value of a task's field '" + key + "'.")
+			fieldError(L, "task", task.Name, task.Source, key, "a string or an array of strings", value)
 		}
 	case "description":
 		if descStr, ok := toString(value); ok {
 			task.Description = descStr
 		} else {
-			panic("invalid value of a task's field '" + key + "'.")
+			fieldError(L, "task", task.Name, task.Source, key, "a string", value)
+		}
+	case "long_description":
+		if descStr, ok := toString(value); ok {
+			task.LongDescription = descStr
+		} else {
+			fieldError(L, "task", task.Name, task.Source, key, "a string", value)
 		}
 	case "pty":
 		if ptyBool, ok := toBool(value); ok {
 			task.Pty = ptyBool
 		} else {
-			panic("invalid value of a task's field '" + key + "'.")
+			fieldError(L, "task", task.Name, task.Source, key, "a boolean", value)
 		}
 	case "driver":
 		if driverStr, ok := toString(value); ok {
 			task.Driver = driverStr
 		} else {
-			panic("invalid value of a task's field '" + key + "'.")
+			fieldError(L, "task", task.Name, task.Source, key, "a string", value)
 		}
 	case "parallel":
 		if parallelBool, ok := toBool(value); ok {
 			task.Parallel = parallelBool
 		} else {
-			panic("invalid value of a task's field '" + key + "'.")
+			fieldError(L, "task", task.Name, task.Source, key, "a boolean", value)
 		}
 	case "user":
 		if userStr, ok := toString(value); ok {
 			task.User = userStr
 		} else {
-			panic("invalid value of a task's field '" + key + "'.")
+			fieldError(L, "task", task.Name, task.Source, key, "a string", value)
 		}
 	case "privileged":
 		if privilegedBool, ok := toBool(value); ok {
 			task.Privileged = privilegedBool
 		} else {
-			panic("invalid value of a task's field '" + key + "'.")
+			fieldError(L, "task", task.Name, task.Source, key, "a boolean", value)
 		}
 	case "ssh_options":
 		if sshOptionsSlice, ok := toSlice(value); ok {
@@ -321,37 +375,44 @@ func updateTask(L *lua.LState, task *Task, key string, value lua.LValue) {
 				}
 			}
 		}
+	case "host_hooks":
+		if hostHooksBool, ok := toBool(value); ok {
+			task.HostHooks = hostHooksBool
+		} else {
+			fieldError(L, "task", task.Name, task.Source, key, "a boolean", value)
+		}
 	case "disabled":
 		if disabledBool, ok := toBool(value); ok {
 			task.Disabled = disabledBool
 		} else {
-			panic("invalid value of a task's field '" + key + "'.")
+			fieldError(L, "task", task.Name, task.Source, key, "a boolean", value)
 		}
 	case "hidden":
 		if hiddenBool, ok := toBool(value); ok {
 			task.Hidden = hiddenBool
 		} else {
-			panic("invalid value of a task's field '" + key + "'.")
+			fieldError(L, "task", task.Name, task.Source, key, "a boolean", value)
 		}
 	case "script":
 		script, err := toScript(L, value)
 		if err != nil {
-			L.RaiseError("%v", err)
+			fieldErrorWithMessage(L, "task", task.Name, task.Source, key, err.Error())
+			return
 		}
 		task.Script = script
 
 		if task.File != "" && len(task.Script) > 0 {
-			L.RaiseError("invalid task definition: can't use 'script_file' and 'script' at the same time.")
+			fieldErrorWithMessage(L, "task", task.Name, task.Source, key, "can't use 'script_file' and 'script' at the same time.")
 		}
 	case "script_file":
 		if fileStr, ok := toString(value); ok {
-			task.File = fileStr
+			task.File = resolveScriptFile(task, fileStr)
 		} else {
-			panic("invalid value of a task's field '" + key + "'.")
+			fieldError(L, "task", task.Name, task.Source, key, "a string", value)
 		}
 
 		if task.File != "" && len(task.Script) > 0 {
-			L.RaiseError("invalid task definition: can't use 'script_file' and 'script' at the same time.")
+			fieldErrorWithMessage(L, "task", task.Name, task.Source, key, "can't use 'script_file' and 'script' at the same time.")
 		}
 	case "prefix":
 		if prefixBool, ok := toBool(value); ok {
@@ -360,7 +421,7 @@ func updateTask(L *lua.LState, task *Task, key string, value lua.LValue) {
 			task.UsePrefix = true
 			task.Prefix = prefixStr
 		} else {
-			panic("invalid value of a task's field '" + key + "'.")
+			fieldError(L, "task", task.Name, task.Source, key, "a boolean or a string", value)
 		}
 	case "prepare":
 		if prepareFn, ok := value.(*lua.LFunction); ok {
@@ -390,8 +451,16 @@ func updateTask(L *lua.LState, task *Task, key string, value lua.LValue) {
 				return nil
 			}
 		} else {
-			L.RaiseError("prepare have to be a function.")
+			fieldError(L, "task", task.Name, task.Source, key, "a function", value)
 		}
+	case "before":
+		task.Before = toTaskHook(L, task, key, value)
+	case "after":
+		task.After = toTaskHook(L, task, key, value)
+	case "on_success":
+		task.OnSuccess = toTaskHook(L, task, key, value)
+	case "on_failure":
+		task.OnFailure = toTaskHook(L, task, key, value)
 	case "props":
 		if propsTb, ok := toLTable(value); ok {
 			// initialize
@@ -399,19 +468,38 @@ func updateTask(L *lua.LState, task *Task, key string, value lua.LValue) {
 
 			propsTb.ForEach(func(propsKey lua.LValue, propsValue lua.LValue) {
 				propsKeyStr, ok := toString(propsKey)
-				if !ok {
-					L.RaiseError("props table's key must be a string: %v", propsKey)
-				}
-				propsValueStr, ok := toString(propsValue)
-				if !ok {
-					L.RaiseError("props table's value must be a string: %v", propsValue)
+				propsValueStr, ok2 := toString(propsValue)
+				if !ok || !ok2 {
+					fieldError(L, "task", task.Name, task.Source, key, "a table of strings", value)
+					return
 				}
 
 				task.Props[propsKeyStr] = propsValueStr
 			})
 		} else {
-			panic("invalid value of a task's field '" + key + "'.")
+			fieldError(L, "task", task.Name, task.Source, key, "a table of strings", value)
+		}
+	case "env":
+		if env, err := toEnv(value); err == nil {
+			task.Env = env
+		} else {
+			fieldErrorWithMessage(L, "task", task.Name, task.Source, key, err.Error())
 		}
+	case "sensitive":
+		if sensitiveSlice, ok := toSlice(value); ok {
+			task.Sensitive = []string{}
+			for _, sensitive := range sensitiveSlice {
+				if sensitiveStr, ok := sensitive.(string); ok {
+					task.Sensitive = append(task.Sensitive, sensitiveStr)
+				}
+			}
+		} else if sensitiveStr, ok := toString(value); ok {
+			task.Sensitive = []string{sensitiveStr}
+		} else {
+			fieldError(L, "task", task.Name, task.Source, key, "a string or an array of strings", value)
+		}
+	case "params":
+		task.Params = toTaskParams(L, task, value)
 	case "args":
 		if argsSlice, ok := toSlice(value); ok {
 			task.Args = []string{}
@@ -422,13 +510,55 @@ func updateTask(L *lua.LState, task *Task, key string, value lua.LValue) {
 				}
 			}
 		} else {
-			panic("invalid value of a task's field '" + key + "'.")
+			fieldError(L, "task", task.Name, task.Source, key, "an array of strings", value)
 		}
 	default:
-		panic("unsupported task's field '" + key + "'.")
+		fieldError(L, "task", task.Name, task.Source, key, "", value)
 	}
 }
 
+// resolveScriptFile resolves the relative path of the script_file from the directory of the config file that defines the task,
+// because the config file may be found in the parent directory or included from another directory.
+func resolveScriptFile(task *Task, path string) string {
+	if path == "" || filepath.IsAbs(path) || strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		return path
+	}
+
+	file := ""
+	if len(LoadingConfigFiles) > 0 {
+		file = LoadingConfigFiles[len(LoadingConfigFiles)-1]
+	} else if task.Source != "" {
+		// the field is updated after loading the config files. the source is like "path:line".
+		file = task.Source
+		if i := strings.LastIndex(file, ":"); i > 0 {
+			if _, err := strconv.Atoi(file[i+1:]); err == nil {
+				file = file[:i]
+			}
+		}
+	}
+
+	// the relative path in the per-user config is resolved from the current directory as before.
+	if file == "" || isUserConfigFile(file) {
+		return path
+	}
+
+	return filepath.Join(filepath.Dir(file), path)
+}
+
+// isUserConfigFile reports whether the file is the per-user config file or its override.
+// The files that are included by them are not the per-user config files.
+func isUserConfigFile(file string) bool {
+	for _, path := range []string{UserConfigFile, UserOverrideConfigFile} {
+		for _, f := range configFiles(path) {
+			if f == file {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 func toScript(L *lua.LState, value lua.LValue) ([]map[string]string, error) {
 	ret := []map[string]string{}
 
@@ -440,12 +570,14 @@ func toScript(L *lua.LState, value lua.LValue) ([]map[string]string, error) {
 			}
 
 			m := map[string]string{}
+			var err error
 			tb.ForEach(func(k, v lua.LValue) {
 				vs, ok := toString(v)
 				if !ok {
 					vb, ok := toBool(v)
 					if !ok {
-						panic("if a 'script' entry is table, it's value has to be string or bool.")
+						err = fmt.Errorf("if a 'script' entry is table, it's value has to be string or bool.")
+						return
 					}
 					if vb {
 						vs = "true"
@@ -455,10 +587,14 @@ func toScript(L *lua.LState, value lua.LValue) ([]map[string]string, error) {
 				}
 				ks, ok := toString(k)
 				if !ok {
-					panic("if a 'script' entry is table, it's property has to be string.")
+					err = fmt.Errorf("if a 'script' entry is table, it's property has to be string.")
+					return
 				}
 				m[ks] = vs
 			})
+			if err != nil {
+				return nil, err
+			}
 
 			ret = append(ret, m)
 		} else { // array
@@ -499,3 +635,36 @@ func toScript(L *lua.LState, value lua.LValue) ([]map[string]string, error) {
 
 	return nil, fmt.Errorf("'script' got a invalid value.")
 }
+
+// toTaskHook converts a Lua function to a hook that receives the result of the task.
+func toTaskHook(L *lua.LState, task *Task, key string, value lua.LValue) func(result *TaskResult) error {
+	if value == lua.LNil {
+		return nil
+	}
+
+	hookFn, ok := value.(*lua.LFunction)
+	if !ok {
+		fieldError(L, "task", task.Name, task.Source, key, "a function", value)
+		return nil
+	}
+
+	return func(result *TaskResult) error {
+		err := L.CallByParam(lua.P{
+			Fn:      hookFn,
+			NRet:    1,
+			Protect: true,
+		}, newLTaskResult(L, result))
+		if err != nil {
+			return fmt.Errorf("%s hook of the task '%s' failed: %v", key, task.Name, err)
+		}
+
+		ret := L.Get(-1) // returned value
+		L.Pop(1)
+
+		if retB, ok := ret.(lua.LBool); ok && !bool(retB) {
+			return fmt.Errorf("returned false from the %s hook of the task '%s'.", key, task.Name)
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,118 @@
+package essh
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+// captureStdout returns the output that fn writes to the stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() {
+		os.Stdout = stdout
+	}()
+
+	done := make(chan string)
+	go func() {
+		var b bytes.Buffer
+		io.Copy(&b, r)
+		done <- b.String()
+	}()
+
+	fn()
+	w.Close()
+
+	return <-done
+}
+
+func TestPrintTaskHelp(t *testing.T) {
+	initResources()
+
+	web01 := NewHost()
+	web01.Name = "web01"
+	web01.Tags = []string{"web"}
+	Hosts["web01"] = web01
+
+	web02 := NewHost()
+	web02.Name = "web02"
+	web02.Tags = []string{"web"}
+	Hosts["web02"] = web02
+
+	env := NewTaskParam()
+	env.Name = "env"
+	env.Description = "target environment"
+	env.Required = true
+	env.Choices = []string{"prod", "stg"}
+
+	force := NewTaskParam()
+	force.Name = "force"
+	force.Type = TASK_PARAM_TYPE_BOOL
+
+	task := NewTask()
+	task.Name = "deploy"
+	task.Description = "deploy the app"
+	task.LongDescription = "\nDeploy the app to the web servers.\n"
+	task.Backend = TASK_BACKEND_REMOTE
+	task.Targets = []string{"web"}
+	task.Parallel = true
+	task.Params = map[string]*TaskParam{"env": env, "force": force}
+	task.Source = "esshconfig.lua:10"
+
+	output := captureStdout(t, func() {
+		printTaskHelp(task)
+	})
+
+	expected := `Usage: essh deploy --env=<prod|stg> [--force] [<args...>]
+
+deploy the app
+
+Deploy the app to the web servers.
+
+Params:
+  --env=<prod|stg>               target environment
+  [--force]
+
+Settings:
+  targets:     web
+  filters:     
+  backend:     remote
+  driver:      default
+  parallel:    true
+  privileged:  false
+  user:        
+  pty:         false
+  hosts:       web01, web02
+  registry:    
+  source:      esshconfig.lua:10
+
+`
+	if output != expected {
+		t.Errorf("expected:\n%s\nbut got:\n%s", expected, output)
+	}
+}
+
+func TestPrintTaskHelpWithoutParams(t *testing.T) {
+	initResources()
+
+	task := NewTask()
+	task.Name = "hello"
+
+	output := captureStdout(t, func() {
+		printTaskHelp(task)
+	})
+
+	if !bytes.HasPrefix([]byte(output), []byte("Usage: essh hello [<args...>]\n\n")) {
+		t.Errorf("unexpected usage: %s", output)
+	}
+	if bytes.Contains([]byte(output), []byte("Params:")) {
+		t.Errorf("the task without params must not show 'Params:': %s", output)
+	}
+}
@@ -0,0 +1,120 @@
+package essh
+
+import (
+	"errors"
+	"github.com/yuin/gopher-lua"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunTaskAfterHooks(t *testing.T) {
+	cases := []struct {
+		name   string
+		code   string
+		err    error
+		called string
+		error  string
+	}{
+		{
+			name:   "success",
+			code:   `on_success = function(r) called = called .. "on_success," end; on_failure = function(r) called = called .. "on_failure," end; after = function(r) called = called .. "after" end`,
+			called: "on_success,after",
+		},
+		{
+			name:   "failure",
+			code:   `on_success = function(r) called = called .. "on_success," end; on_failure = function(r) called = called .. "on_failure," end; after = function(r) called = called .. "after" end`,
+			err:    errors.New("script failed"),
+			called: "on_failure,after",
+		},
+		{
+			name:   "returned false",
+			code:   `on_success = function(r) called = called .. "on_success,"; return false end; after = function(r) called = called .. "after" end`,
+			called: "on_success,after",
+			error:  "returned false from the on_success hook of the task 'deploy'.",
+		},
+		{
+			name:   "after error wins",
+			code:   `on_success = function(r) return false end; after = function(r) called = "after"; error("after failed") end`,
+			called: "after",
+			error:  "after hook of the task 'deploy' failed:",
+		},
+	}
+
+	for _, c := range cases {
+		L := lua.NewState()
+		if err := L.DoString(`called = ""; ` + c.code); err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.name, err)
+		}
+
+		task := NewTask()
+		task.Name = "deploy"
+		task.OnSuccess = toTaskHook(L, task, "on_success", L.GetGlobal("on_success"))
+		task.OnFailure = toTaskHook(L, task, "on_failure", L.GetGlobal("on_failure"))
+		task.After = toTaskHook(L, task, "after", L.GetGlobal("after"))
+
+		result := NewTaskResult(task, []string{}, []*Host{})
+		result.Finish(c.err)
+
+		err := runTaskAfterHooks(task, result)
+		if c.error == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+		}
+		if c.error != "" && (err == nil || !strings.Contains(err.Error(), c.error)) {
+			t.Errorf("%s: expected error %q but got %v", c.name, c.error, err)
+		}
+		if called := L.GetGlobal("called").String(); called != c.called {
+			t.Errorf("%s: expected %q to be called but got %q", c.name, c.called, called)
+		}
+
+		L.Close()
+	}
+}
+
+func TestNewLTaskResult(t *testing.T) {
+	L := lua.NewState()
+	defer L.Close()
+
+	task := NewTask()
+	task.Name = "deploy"
+
+	web01 := NewHost()
+	web01.Name = "web01"
+	web02 := NewHost()
+	web02.Name = "web02"
+
+	result := NewTaskResult(task, []string{"v1"}, []*Host{web01, web02})
+
+	tb := newLTaskResult(L, result)
+	if tb.RawGetString("task").String() != "deploy" || tb.RawGetString("args").(*lua.LTable).RawGetInt(1).String() != "v1" {
+		t.Errorf("unexpected task or args: %v, %v", tb.RawGetString("task"), tb.RawGetString("args"))
+	}
+	if tb.RawGetString("hosts").(*lua.LTable).Len() != 2 {
+		t.Errorf("expected 2 hosts but got %v", tb.RawGetString("hosts"))
+	}
+	if tb.RawGetString("success") != lua.LNil {
+		t.Errorf("the result before running the scripts must not have 'success'")
+	}
+
+	result.AddHostResult(web01, time.Now(), nil)
+	result.AddHostResult(web02, time.Now(), errors.New("failed"))
+	result.Finish(errors.New("failed on the hosts: web02"))
+
+	tb = newLTaskResult(L, result)
+	if tb.RawGetString("success") != lua.LFalse || tb.RawGetString("error").String() != "failed on the hosts: web02" {
+		t.Errorf("unexpected success or error: %v, %v", tb.RawGetString("success"), tb.RawGetString("error"))
+	}
+
+	failedHosts := tb.RawGetString("failed_hosts").(*lua.LTable)
+	if failedHosts.Len() != 1 || failedHosts.RawGetInt(1).String() != "web02" {
+		t.Errorf("unexpected failed_hosts: %v", failedHosts)
+	}
+
+	results := tb.RawGetString("results").(*lua.LTable)
+	if code := results.RawGetString("web01").(*lua.LTable).RawGetString("exit_code").String(); code != "0" {
+		t.Errorf("expected the exit code of 'web01' to be 0 but got %s", code)
+	}
+	if e := results.RawGetString("web02").(*lua.LTable).RawGetString("error").String(); e != "failed" {
+		t.Errorf("expected the error of 'web02' but got %s", e)
+	}
+}
@@ -0,0 +1,295 @@
+package essh
+
+import (
+	"fmt"
+	"github.com/yuin/gopher-lua"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type TaskParam struct {
+	Name        string
+	Description string
+	Type        string
+	Required    bool
+	Default     string
+	HasDefault  bool
+	Choices     []string
+}
+
+const (
+	TASK_PARAM_TYPE_STRING = "string"
+	TASK_PARAM_TYPE_NUMBER = "number"
+	TASK_PARAM_TYPE_BOOL   = "bool"
+)
+
+func NewTaskParam() *TaskParam {
+	return &TaskParam{
+		Type:    TASK_PARAM_TYPE_STRING,
+		Choices: []string{},
+	}
+}
+
+func (p *TaskParam) IsBool() bool {
+	return p.Type == TASK_PARAM_TYPE_BOOL
+}
+
+func (p *TaskParam) Validate(value string) error {
+	switch p.Type {
+	case TASK_PARAM_TYPE_NUMBER:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("parameter '--%s' must be a number but got '%s'.", p.Name, value)
+		}
+	case TASK_PARAM_TYPE_BOOL:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("parameter '--%s' must be a bool but got '%s'.", p.Name, value)
+		}
+	}
+
+	if len(p.Choices) > 0 {
+		for _, choice := range p.Choices {
+			if choice == value {
+				return nil
+			}
+		}
+		return fmt.Errorf("parameter '--%s' must be one of '%s' but got '%s'.", p.Name, strings.Join(p.Choices, "|"), value)
+	}
+
+	return nil
+}
+
+// Usage returns a short usage string like "--env=<prod|stg>" or "[--count=<number>]".
+func (p *TaskParam) Usage() string {
+	var placeholder string
+	if len(p.Choices) > 0 {
+		placeholder = "<" + strings.Join(p.Choices, "|") + ">"
+	} else {
+		placeholder = "<" + p.Type + ">"
+	}
+
+	usage := "--" + p.Name
+	if !p.IsBool() {
+		usage += "=" + placeholder
+	}
+
+	if p.HasDefault {
+		usage += " (default: " + p.Default + ")"
+	}
+
+	if !p.Required {
+		usage = "[" + usage + "]"
+	}
+
+	return usage
+}
+
+func (t *Task) SortedParams() []*TaskParam {
+	var names []string
+	for name, _ := range t.Params {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	params := []*TaskParam{}
+	for _, name := range names {
+		params = append(params, t.Params[name])
+	}
+
+	return params
+}
+
+func (t *Task) ParamsUsage() string {
+	usages := []string{}
+	for _, p := range t.SortedParams() {
+		usages = append(usages, p.Usage())
+	}
+
+	return strings.Join(usages, " ")
+}
+
+// ParseParams parses "--name=value" and "--name value" style arguments by the declared params.
+// It returns positional arguments that are not params.
+// If the task does not declare any params, all arguments are treated as positional arguments.
+func (t *Task) ParseParams(args []string) (map[string]string, []string, error) {
+	values := map[string]string{}
+	positionals := []string{}
+
+	if len(t.Params) == 0 {
+		return values, args, nil
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		if arg == "--" {
+			positionals = append(positionals, args[i+1:]...)
+			break
+		}
+
+		if !strings.HasPrefix(arg, "--") {
+			positionals = append(positionals, arg)
+			continue
+		}
+
+		name := strings.TrimPrefix(arg, "--")
+		value := ""
+		hasValue := false
+		if idx := strings.Index(name, "="); idx >= 0 {
+			value = name[idx+1:]
+			name = name[:idx]
+			hasValue = true
+		}
+
+		p := t.Params[name]
+		if p == nil {
+			return nil, nil, fmt.Errorf("task '%s' does not have a parameter '--%s'.", t.Name, name)
+		}
+
+		if !hasValue {
+			if p.IsBool() {
+				value = "true"
+			} else {
+				if i+1 >= len(args) {
+					return nil, nil, fmt.Errorf("parameter '--%s' requires an argument.", name)
+				}
+				value = args[i+1]
+				i++
+			}
+		}
+
+		values[name] = value
+	}
+
+	for _, p := range t.SortedParams() {
+		if _, ok := values[p.Name]; !ok {
+			if p.HasDefault {
+				values[p.Name] = p.Default
+			} else if p.Required {
+				return nil, nil, fmt.Errorf("task '%s' requires a parameter '--%s'.", t.Name, p.Name)
+			} else {
+				continue
+			}
+		}
+
+		if err := p.Validate(values[p.Name]); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return values, positionals, nil
+}
+
+// isEsshOption reports whether the name is used by an essh option.
+// A param that has the same name never reaches the task, because Essh consumes the option.
+func isEsshOption(name string) bool {
+	for _, option := range esshOptions {
+		if option == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func toTaskParams(L *lua.LState, task *Task, value lua.LValue) map[string]*TaskParam {
+	fail := func(format string, a ...interface{}) {
+		fieldErrorWithMessage(L, "task", task.Name, task.Source, "params", fmt.Sprintf(format, a...))
+	}
+
+	params := map[string]*TaskParam{}
+	paramsTb, ok := toLTable(value)
+	if !ok {
+		fieldError(L, "task", task.Name, task.Source, "params", "a table", value)
+		return params
+	}
+
+	paramsTb.ForEach(func(k lua.LValue, v lua.LValue) {
+		p := NewTaskParam()
+
+		if name, ok := toString(v); ok {
+			// array style: params = {"env", "count"}
+			if isEsshOption(name) {
+				fail("param '%s' conflicts with the essh option '--%s'.", name, name)
+				return
+			}
+			p.Name = name
+			params[p.Name] = p
+			return
+		}
+
+		name, ok := toString(k)
+		if !ok {
+			fail("params table's key must be a string: %v", k)
+			return
+		}
+		if isEsshOption(name) {
+			fail("param '%s' conflicts with the essh option '--%s'.", name, name)
+			return
+		}
+		p.Name = name
+
+		configTb, ok := toLTable(v)
+		if !ok {
+			fail("param '%s' must be a table.", name)
+			return
+		}
+
+		configTb.ForEach(func(ck lua.LValue, cv lua.LValue) {
+			ckStr, _ := toString(ck)
+			switch ckStr {
+			case "type":
+				typeStr, ok := toString(cv)
+				if !ok || (typeStr != TASK_PARAM_TYPE_STRING && typeStr != TASK_PARAM_TYPE_NUMBER && typeStr != TASK_PARAM_TYPE_BOOL) {
+					fail("param '%s' type must be '%s', '%s' or '%s'.", name, TASK_PARAM_TYPE_STRING, TASK_PARAM_TYPE_NUMBER, TASK_PARAM_TYPE_BOOL)
+					return
+				}
+				p.Type = typeStr
+			case "description":
+				descStr, ok := toString(cv)
+				if !ok {
+					fail("param '%s' description must be a string.", name)
+					return
+				}
+				p.Description = descStr
+			case "required":
+				requiredBool, ok := toBool(cv)
+				if !ok {
+					fail("param '%s' required must be a bool.", name)
+					return
+				}
+				p.Required = requiredBool
+			case "default":
+				if cv == lua.LNil {
+					return
+				}
+				p.Default = cv.String()
+				p.HasDefault = true
+			case "choices":
+				choicesSlice, ok := toSlice(cv)
+				if !ok {
+					fail("param '%s' choices must be an array table.", name)
+					return
+				}
+				p.Choices = []string{}
+				for _, choice := range choicesSlice {
+					p.Choices = append(p.Choices, fmt.Sprintf("%v", choice))
+				}
+			default:
+				fail("unsupported param's field '%s'.", ckStr)
+			}
+		})
+
+		if p.HasDefault {
+			if err := p.Validate(p.Default); err != nil {
+				fail("invalid default value: %v", err)
+				return
+			}
+		}
+
+		params[p.Name] = p
+	})
+
+	return params
+}
@@ -0,0 +1,137 @@
+package essh
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newParamsTask() *Task {
+	task := NewTask()
+	task.Name = "deploy"
+
+	env := NewTaskParam()
+	env.Name = "env"
+	env.Required = true
+	env.Choices = []string{"prod", "stg"}
+
+	count := NewTaskParam()
+	count.Name = "count"
+	count.Type = TASK_PARAM_TYPE_NUMBER
+	count.Default = "1"
+	count.HasDefault = true
+
+	force := NewTaskParam()
+	force.Name = "force"
+	force.Type = TASK_PARAM_TYPE_BOOL
+
+	task.Params = map[string]*TaskParam{"env": env, "count": count, "force": force}
+
+	return task
+}
+
+func TestParseParams(t *testing.T) {
+	cases := []struct {
+		name        string
+		args        []string
+		values      map[string]string
+		positionals []string
+		err         string
+	}{
+		{
+			name:        "equal style",
+			args:        []string{"--env=prod", "--force"},
+			values:      map[string]string{"env": "prod", "count": "1", "force": "true"},
+			positionals: []string{},
+		},
+		{
+			name:        "space style",
+			args:        []string{"--env", "stg", "--count", "3"},
+			values:      map[string]string{"env": "stg", "count": "3"},
+			positionals: []string{},
+		},
+		{
+			name:        "positionals",
+			args:        []string{"a", "--env=prod", "b", "--", "--force"},
+			values:      map[string]string{"env": "prod", "count": "1"},
+			positionals: []string{"a", "b", "--force"},
+		},
+		{
+			name:        "bool value",
+			args:        []string{"--env=prod", "--force=false"},
+			values:      map[string]string{"env": "prod", "count": "1", "force": "false"},
+			positionals: []string{},
+		},
+		{name: "missing required", args: []string{"--force"}, err: "requires a parameter '--env'"},
+		{name: "unknown param", args: []string{"--env=prod", "--user=foo"}, err: "does not have a parameter '--user'"},
+		{name: "missing value", args: []string{"--env"}, err: "'--env' requires an argument"},
+		{name: "invalid choice", args: []string{"--env=dev"}, err: "must be one of 'prod|stg'"},
+		{name: "invalid number", args: []string{"--env=prod", "--count=x"}, err: "must be a number"},
+		{name: "invalid bool", args: []string{"--env=prod", "--force=x"}, err: "must be a bool"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			values, positionals, err := newParamsTask().ParseParams(c.args)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("expected error containing %q but got %v", c.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(values, c.values) {
+				t.Errorf("values: expected %v but got %v", c.values, values)
+			}
+			if !reflect.DeepEqual(positionals, c.positionals) {
+				t.Errorf("positionals: expected %v but got %v", c.positionals, positionals)
+			}
+		})
+	}
+}
+
+func TestParseParamsWithoutDeclaredParams(t *testing.T) {
+	task := NewTask()
+	args := []string{"--user=foo", "bar"}
+
+	values, positionals, err := task.ParseParams(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 0 || !reflect.DeepEqual(positionals, args) {
+		t.Errorf("expected all args as positionals but got %v %v", values, positionals)
+	}
+}
+
+func TestTaskParamsConflictWithEsshOptions(t *testing.T) {
+	cases := []struct {
+		code string
+		err  string
+	}{
+		{code: `task "t" { params = { user = {} } }`, err: "param 'user' conflicts with the essh option '--user'."},
+		{code: `task "t" { params = { "target" } }`, err: "param 'target' conflicts with the essh option '--target'."},
+		{code: `task "t" { params = { env = {}, "count" } }`},
+	}
+
+	for _, c := range cases {
+		L := newTestLState()
+		err := L.DoString(c.code)
+		L.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if c.err == "" {
+			if len(CollectedConfigErrors) != 0 {
+				t.Errorf("%s: unexpected errors: %v", c.code, CollectedConfigErrors)
+			}
+			continue
+		}
+
+		if len(CollectedConfigErrors) != 1 || !strings.Contains(CollectedConfigErrors[0].Error(), c.err) {
+			t.Errorf("%s: expected error containing %q but got %v", c.code, c.err, CollectedConfigErrors)
+		}
+	}
+}
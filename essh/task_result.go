@@ -0,0 +1,185 @@
+package essh
+
+import (
+	"github.com/Songmu/wrapcommander"
+	"github.com/yuin/gopher-lua"
+	"sync"
+	"time"
+)
+
+// TaskResult is a result of a task execution.
+type TaskResult struct {
+	Task        *Task
+	Args        []string
+	Hosts       []*Host
+	HostResults []*HostResult
+	StartTime   time.Time
+	EndTime     time.Time
+	Error       error
+	m           *sync.Mutex
+}
+
+// HostResult is a result of a task's script execution with a host.
+// If the task runs locally without hosts, Host is nil.
+type HostResult struct {
+	Host      *Host
+	ExitCode  int
+	Error     error
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+const LocalHostResultName = "(local)"
+
+func NewTaskResult(task *Task, args []string, hosts []*Host) *TaskResult {
+	return &TaskResult{
+		Task:        task,
+		Args:        args,
+		Hosts:       hosts,
+		HostResults: []*HostResult{},
+		StartTime:   time.Now(),
+		m:           new(sync.Mutex),
+	}
+}
+
+func (r *TaskResult) AddHostResult(host *Host, startTime time.Time, err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.HostResults = append(r.HostResults, &HostResult{
+		Host:      host,
+		ExitCode:  resolveExitCode(err),
+		Error:     err,
+		StartTime: startTime,
+		EndTime:   time.Now(),
+	})
+}
+
+// resolveExitCode returns the exit code of the error.
+// The errors that have their own exit status like the fake backend's failures don't need wrapcommander.
+func resolveExitCode(err error) int {
+	if e, ok := err.(interface {
+		ExitStatus() int
+	}); ok {
+		return e.ExitStatus()
+	}
+
+	return wrapcommander.ResolveExitCode(err)
+}
+
+func (r *TaskResult) Finish(err error) {
+	r.EndTime = time.Now()
+	r.Error = err
+}
+
+func (r *TaskResult) HostNames() []string {
+	names := []string{}
+	for _, host := range r.Hosts {
+		names = append(names, host.Name)
+	}
+
+	return names
+}
+
+func (r *TaskResult) ExitCodes() map[string]int {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	exitCodes := map[string]int{}
+	for _, hostResult := range r.HostResults {
+		exitCodes[hostResult.HostName()] = hostResult.ExitCode
+	}
+
+	return exitCodes
+}
+
+func (r *TaskResult) FailedHosts() []string {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	failed := []string{}
+	for _, hostResult := range r.HostResults {
+		if hostResult.Error != nil {
+			failed = append(failed, hostResult.HostName())
+		}
+	}
+
+	return failed
+}
+
+func (r *TaskResult) SucceededHosts() []string {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	succeeded := []string{}
+	for _, hostResult := range r.HostResults {
+		if hostResult.Error == nil {
+			succeeded = append(succeeded, hostResult.HostName())
+		}
+	}
+
+	return succeeded
+}
+
+func (r *HostResult) HostName() string {
+	if r.Host == nil {
+		return LocalHostResultName
+	}
+
+	return r.Host.Name
+}
+
+func (r *HostResult) Duration() time.Duration {
+	return r.EndTime.Sub(r.StartTime)
+}
+
+// newLTaskResult converts the result to a Lua table that is passed to the task's hooks.
+// Before running the scripts, the table has only the task, args and hosts.
+func newLTaskResult(L *lua.LState, r *TaskResult) *lua.LTable {
+	tb := L.NewTable()
+	tb.RawSetString("task", lua.LString(r.Task.Name))
+
+	args := L.NewTable()
+	for _, arg := range r.Args {
+		args.Append(lua.LString(arg))
+	}
+	tb.RawSetString("args", args)
+
+	hosts := L.NewTable()
+	for _, name := range r.HostNames() {
+		hosts.Append(lua.LString(name))
+	}
+	tb.RawSetString("hosts", hosts)
+
+	if r.EndTime.IsZero() {
+		return tb
+	}
+
+	tb.RawSetString("success", lua.LBool(r.Error == nil))
+	if r.Error != nil {
+		tb.RawSetString("error", lua.LString(r.Error.Error()))
+	}
+	tb.RawSetString("duration", lua.LNumber(r.EndTime.Sub(r.StartTime).Seconds()))
+
+	failedHosts := L.NewTable()
+	for _, name := range r.FailedHosts() {
+		failedHosts.Append(lua.LString(name))
+	}
+	tb.RawSetString("failed_hosts", failedHosts)
+
+	results := L.NewTable()
+	r.m.Lock()
+	for _, hostResult := range r.HostResults {
+		htb := L.NewTable()
+		htb.RawSetString("exit_code", lua.LNumber(hostResult.ExitCode))
+		htb.RawSetString("duration", lua.LNumber(hostResult.Duration().Seconds()))
+		if hostResult.Error != nil {
+			htb.RawSetString("error", lua.LString(hostResult.Error.Error()))
+		}
+		results.RawSetString(hostResult.HostName(), htb)
+	}
+	r.m.Unlock()
+	tb.RawSetString("results", results)
+
+	return tb
+}
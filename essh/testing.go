@@ -0,0 +1,696 @@
+package essh
+
+import (
+	"encoding/xml"
+	"fmt"
+	"github.com/yuin/gopher-lua"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TestFileSuffix is a suffix of the files that are run by --test.
+const TestFileSuffix = "_test.lua"
+
+const (
+	TestFormatTAP   = "tap"
+	TestFormatJUnit = "junit"
+)
+
+// FakeExecution is a command that a task would run with a host.
+type FakeExecution struct {
+	Task       string
+	Host       string
+	Backend    string
+	Script     string
+	Command    []string
+	User       string
+	Privileged bool
+	Pty        bool
+	SSHOptions []string
+}
+
+// FakeHook is a shell hook of the host that would run.
+type FakeHook struct {
+	Name   string
+	Script string
+}
+
+// FakeBackend records the commands that the tasks would run instead of spawning the processes.
+type FakeBackend struct {
+	Executions []*FakeExecution
+	Hooks      []*FakeHook
+	FailHosts  map[string]bool
+	m          *sync.Mutex
+}
+
+// CurrentFakeBackend is used by the tasks instead of running the commands, if it is not nil.
+var CurrentFakeBackend *FakeBackend
+
+func NewFakeBackend() *FakeBackend {
+	return &FakeBackend{
+		Executions: []*FakeExecution{},
+		Hooks:      []*FakeHook{},
+		FailHosts:  map[string]bool{},
+		m:          new(sync.Mutex),
+	}
+}
+
+// Record records the command. If the host is in FailHosts, it returns an error like the command failed.
+func (b *FakeBackend) Record(task *Task, host *Host, script string, command []string) error {
+	name := LocalHostResultName
+	if host != nil {
+		name = host.Name
+	}
+
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.Executions = append(b.Executions, &FakeExecution{
+		Task:       task.Name,
+		Host:       name,
+		Backend:    task.Backend,
+		Script:     script,
+		Command:    command,
+		User:       task.User,
+		Privileged: task.Privileged,
+		Pty:        task.Pty,
+		SSHOptions: append([]string{}, task.SSHOptions...),
+	})
+
+	if b.FailHosts[name] {
+		return &FakeFailure{Host: name}
+	}
+
+	return nil
+}
+
+// FakeFailure is an error of the command that fails in the fake backend.
+// It has the exit status 1 like a script that failed on a real host.
+type FakeFailure struct {
+	Host string
+}
+
+func (e *FakeFailure) Error() string {
+	return fmt.Sprintf("fake failure on the host '%s'", e.Host)
+}
+
+func (e *FakeFailure) ExitStatus() int {
+	return 1
+}
+
+func (b *FakeBackend) RecordHook(name string, script string) error {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.Hooks = append(b.Hooks, &FakeHook{Name: name, Script: script})
+
+	return nil
+}
+
+// TestCase is a test that is defined by 'test' function in a test file.
+type TestCase struct {
+	Name     string
+	Source   string
+	Failures []string
+	Duration time.Duration
+	fn       *lua.LFunction
+}
+
+func (tc *TestCase) Passed() bool {
+	return len(tc.Failures) == 0
+}
+
+// TestSuite is the tests in a test file.
+type TestSuite struct {
+	File     string
+	Cases    []*TestCase
+	Duration time.Duration
+}
+
+func (s *TestSuite) Failures() int {
+	n := 0
+	for _, tc := range s.Cases {
+		if !tc.Passed() {
+			n++
+		}
+	}
+
+	return n
+}
+
+// FindTestFiles finds the test files in the paths. If a path is a directory, it is searched recursively.
+// The directories that start with '.' (ex. .git and .essh) are skipped.
+func FindTestFiles(paths []string) ([]string, error) {
+	files := []string{}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if p != path && (strings.HasPrefix(info.Name(), ".") || info.Name() == "node_modules" || info.Name() == "vendor") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if strings.HasSuffix(info.Name(), TestFileSuffix) {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(files)
+
+	return files, nil
+}
+
+// runTests runs the tests in the files. The tasks run with the fake backend.
+func runTests(L *lua.LState, config string, files []string) []*TestSuite {
+	// the tests must not leave the records of the fake runs.
+	HistoryFile = ""
+	AuditLogFile = ""
+
+	suites := []*TestSuite{}
+	for _, file := range files {
+		name := file
+		if rel, err := filepath.Rel(WorkingDir, file); err == nil && !strings.HasPrefix(rel, "..") {
+			name = rel
+		}
+
+		suite := &TestSuite{File: name, Cases: []*TestCase{}}
+		startTime := time.Now()
+		snapshot := newTestSnapshot()
+
+		// each test file runs in its own environment that falls back to the globals.
+		// so the globals that the file defines (including "test") do not leak into the config and other files.
+		env := L.NewTable()
+		mt := L.NewTable()
+		mt.RawSetString("__index", L.G.Global)
+		L.SetMetatable(env, mt)
+		env.RawSetString("test", L.NewFunction(func(L *lua.LState) int {
+			suite.Cases = append(suite.Cases, &TestCase{
+				Name:   L.CheckString(1),
+				Source: luaSourcePosition(L),
+				fn:     L.CheckFunction(2),
+			})
+			return 0
+		}))
+
+		if err := doTestFile(L, file, env); err != nil {
+			suite.Cases = append(suite.Cases, &TestCase{
+				Name:     "(load)",
+				Source:   file,
+				Failures: []string{err.Error()},
+			})
+		}
+
+		for _, tc := range suite.Cases {
+			if tc.fn != nil {
+				runTestCase(L, config, tc)
+			}
+		}
+
+		snapshot.restore()
+
+		suite.Duration = time.Since(startTime)
+		suites = append(suites, suite)
+	}
+
+	return suites
+}
+
+func doTestFile(L *lua.LState, file string, env *lua.LTable) error {
+	fn, err := L.LoadFile(file)
+	if err != nil {
+		return err
+	}
+	fn.Env = env
+
+	L.Push(fn)
+	return L.PCall(0, lua.MultRet, nil)
+}
+
+// testSnapshot is the resources before a test file runs.
+// The test files can define and update the resources, so they are restored after each file.
+type testSnapshot struct {
+	hosts   map[string]*Host
+	tasks   map[string]*Task
+	drivers map[string]*Driver
+	tunnels map[string]*Tunnel
+
+	hostValues   map[*Host]Host
+	taskValues   map[*Task]Task
+	driverValues map[*Driver]Driver
+	tunnelValues map[*Tunnel]Tunnel
+}
+
+func newTestSnapshot() *testSnapshot {
+	s := &testSnapshot{
+		hosts:        map[string]*Host{},
+		tasks:        map[string]*Task{},
+		drivers:      map[string]*Driver{},
+		tunnels:      map[string]*Tunnel{},
+		hostValues:   map[*Host]Host{},
+		taskValues:   map[*Task]Task{},
+		driverValues: map[*Driver]Driver{},
+		tunnelValues: map[*Tunnel]Tunnel{},
+	}
+
+	for name, h := range Hosts {
+		s.hosts[name] = h
+		v := *h
+		v.LValues = copyLValues(h.LValues)
+		s.hostValues[h] = v
+	}
+	for name, t := range Tasks {
+		s.tasks[name] = t
+		v := *t
+		v.LValues = copyLValues(t.LValues)
+		s.taskValues[t] = v
+	}
+	for name, d := range Drivers {
+		s.drivers[name] = d
+		v := *d
+		v.LValues = copyLValues(d.LValues)
+		s.driverValues[d] = v
+	}
+	for name, t := range Tunnels {
+		s.tunnels[name] = t
+		v := *t
+		v.LValues = copyLValues(t.LValues)
+		s.tunnelValues[t] = v
+	}
+
+	return s
+}
+
+func (s *testSnapshot) restore() {
+	Hosts = s.hosts
+	Tasks = s.tasks
+	Drivers = s.drivers
+	Tunnels = s.tunnels
+
+	for h, v := range s.hostValues {
+		*h = v
+	}
+	for t, v := range s.taskValues {
+		*t = v
+	}
+	for d, v := range s.driverValues {
+		*d = v
+	}
+	for t, v := range s.tunnelValues {
+		*t = v
+	}
+}
+
+func copyLValues(values map[string]lua.LValue) map[string]lua.LValue {
+	copied := map[string]lua.LValue{}
+	for k, v := range values {
+		copied[k] = v
+	}
+
+	return copied
+}
+
+func runTestCase(L *lua.LState, config string, tc *TestCase) {
+	startTime := time.Now()
+	defer func() {
+		tc.Duration = time.Since(startTime)
+		CurrentFakeBackend = nil
+	}()
+
+	err := L.CallByParam(lua.P{
+		Fn:      tc.fn,
+		NRet:    0,
+		Protect: true,
+	}, newLTestContext(L, &testContext{TestCase: tc, Config: config}))
+	if err != nil {
+		tc.Failures = append(tc.Failures, err.Error())
+	}
+}
+
+func writeTAP(w io.Writer, suites []*TestSuite) {
+	total := 0
+	for _, suite := range suites {
+		total += len(suite.Cases)
+	}
+
+	fmt.Fprintln(w, "TAP version 13")
+	fmt.Fprintf(w, "1..%d\n", total)
+
+	n := 0
+	for _, suite := range suites {
+		for _, tc := range suite.Cases {
+			n++
+			status := "ok"
+			if !tc.Passed() {
+				status = "not ok"
+			}
+			fmt.Fprintf(w, "%s %d - %s: %s\n", status, n, suite.File, tc.Name)
+			for _, failure := range tc.Failures {
+				for _, line := range strings.Split(strings.TrimRight(failure, "\n"), "\n") {
+					fmt.Fprintf(w, "# %s\n", line)
+				}
+			}
+		}
+	}
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name          `xml:"testsuites"`
+	Tests    int               `xml:"tests,attr"`
+	Failures int               `xml:"failures,attr"`
+	Suites   []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Time     string           `xml:"time,attr"`
+	Cases    []*junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Content string `xml:",chardata"`
+}
+
+func writeJUnit(w io.Writer, suites []*TestSuite) error {
+	report := &junitTestSuites{}
+	for _, suite := range suites {
+		js := &junitTestSuite{
+			Name:     suite.File,
+			Tests:    len(suite.Cases),
+			Failures: suite.Failures(),
+			Time:     fmt.Sprintf("%.3f", suite.Duration.Seconds()),
+		}
+		for _, tc := range suite.Cases {
+			jc := &junitTestCase{
+				Name:      tc.Name,
+				ClassName: suite.File,
+				Time:      fmt.Sprintf("%.3f", tc.Duration.Seconds()),
+			}
+			if !tc.Passed() {
+				jc.Failure = &junitFailure{
+					Message: strings.Split(tc.Failures[0], "\n")[0],
+					Content: strings.Join(tc.Failures, "\n"),
+				}
+			}
+			js.Cases = append(js.Cases, jc)
+		}
+		report.Tests += js.Tests
+		report.Failures += js.Failures
+		report.Suites = append(report.Suites, js)
+	}
+
+	b, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprint(w, xml.Header)
+	fmt.Fprintln(w, string(b))
+
+	return nil
+}
+
+// testContext is the object that is passed to the test functions.
+type testContext struct {
+	TestCase *TestCase
+	Config   string
+}
+
+const LTestContextClass = "TestContext*"
+
+func registerTestContextClass(L *lua.LState) {
+	mt := L.NewTypeMetatable(LTestContextClass)
+	L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"ok":        testContextOk,
+		"equal":     testContextEqual,
+		"not_equal": testContextNotEqual,
+		"contains":  testContextContains,
+		"match":     testContextMatch,
+		"error":     testContextError,
+		"fail":      testContextFail,
+		"run":       testContextRun,
+	}))
+}
+
+func newLTestContext(L *lua.LState, ctx *testContext) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = ctx
+	L.SetMetatable(ud, L.GetTypeMetatable(LTestContextClass))
+	return ud
+}
+
+func checkTestContext(L *lua.LState) *testContext {
+	ud := L.CheckUserData(1)
+	if v, ok := ud.Value.(*testContext); ok {
+		return v
+	}
+	L.ArgError(1, "TestContext object expected")
+	return nil
+}
+
+// assert records a failure with the position of the assertion, if the condition is false.
+func (ctx *testContext) assert(L *lua.LState, cond bool, msgIndex int, format string, a ...interface{}) {
+	if cond {
+		return
+	}
+
+	msg := fmt.Sprintf(format, a...)
+	if custom := L.OptString(msgIndex, ""); custom != "" {
+		msg = custom + ": " + msg
+	}
+
+	ctx.TestCase.Failures = append(ctx.TestCase.Failures, luaSourcePosition(L)+": "+msg)
+}
+
+func testContextOk(L *lua.LState) int {
+	ctx := checkTestContext(L)
+	v := L.Get(2)
+	ctx.assert(L, lua.LVAsBool(v), 3, "expected a truthy value but got %s", formatLValue(v))
+	return 0
+}
+
+func testContextEqual(L *lua.LState) int {
+	ctx := checkTestContext(L)
+	actual := L.Get(2)
+	expected := L.Get(3)
+	ctx.assert(L, luaDeepEqual(actual, expected), 4, "expected %s but got %s", formatLValue(expected), formatLValue(actual))
+	return 0
+}
+
+func testContextNotEqual(L *lua.LState) int {
+	ctx := checkTestContext(L)
+	actual := L.Get(2)
+	expected := L.Get(3)
+	ctx.assert(L, !luaDeepEqual(actual, expected), 4, "expected not %s", formatLValue(expected))
+	return 0
+}
+
+// testContextContains checks a string contains the substring, or an array table contains the value.
+func testContextContains(L *lua.LState) int {
+	ctx := checkTestContext(L)
+	container := L.Get(2)
+	v := L.Get(3)
+
+	found := false
+	if tb, ok := container.(*lua.LTable); ok {
+		tb.ForEach(func(_ lua.LValue, elem lua.LValue) {
+			if luaDeepEqual(elem, v) {
+				found = true
+			}
+		})
+	} else if s, ok := container.(lua.LString); ok {
+		found = strings.Contains(string(s), lua.LVAsString(v))
+	}
+
+	ctx.assert(L, found, 4, "expected %s to contain %s", formatLValue(container), formatLValue(v))
+	return 0
+}
+
+// testContextMatch checks the string matches the Go regular expression.
+func testContextMatch(L *lua.LState) int {
+	ctx := checkTestContext(L)
+	s := L.CheckString(2)
+	pattern := L.CheckString(3)
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		L.ArgError(3, err.Error())
+	}
+
+	ctx.assert(L, re.MatchString(s), 4, "expected %q to match /%s/", s, pattern)
+	return 0
+}
+
+// testContextError checks the function raises an error.
+func testContextError(L *lua.LState) int {
+	ctx := checkTestContext(L)
+	fn := L.CheckFunction(2)
+
+	err := L.CallByParam(lua.P{
+		Fn:      fn,
+		NRet:    0,
+		Protect: true,
+	})
+	ctx.assert(L, err != nil, 3, "expected an error but got nothing")
+	return 0
+}
+
+func testContextFail(L *lua.LState) int {
+	ctx := checkTestContext(L)
+	ctx.assert(L, false, 2, "failed")
+	return 0
+}
+
+// testContextRun runs the task with the fake backend and returns the recorded executions.
+//
+//	local r = t:run("deploy", {"--env=prod"}, {fail_hosts = {"web01"}})
+func testContextRun(L *lua.LState) int {
+	ctx := checkTestContext(L)
+	name := L.CheckString(2)
+
+	args := []string{}
+	if tb, ok := L.Get(3).(*lua.LTable); ok {
+		for i := 1; i <= tb.MaxN(); i++ {
+			args = append(args, lua.LVAsString(tb.RawGetInt(i)))
+		}
+	}
+
+	backend := NewFakeBackend()
+	if opts, ok := L.Get(4).(*lua.LTable); ok {
+		if failHosts, ok := toSlice(opts.RawGetString("fail_hosts")); ok {
+			for _, h := range failHosts {
+				if hs, ok := h.(string); ok {
+					backend.FailHosts[hs] = true
+				}
+			}
+		}
+	}
+
+	task := GetEnabledTask(name)
+	if task == nil {
+		L.RaiseError("task '%s' is not defined.", name)
+	}
+
+	registry := CurrentRegistry
+	CurrentFakeBackend = backend
+	err := runTask(ctx.Config, task, args, L)
+	CurrentFakeBackend = nil
+	CurrentRegistry = registry
+
+	L.Push(newLFakeRun(L, backend, err))
+	return 1
+}
+
+func newLFakeRun(L *lua.LState, backend *FakeBackend, err error) *lua.LTable {
+	tb := L.NewTable()
+	if err != nil {
+		tb.RawSetString("error", lua.LString(err.Error()))
+	}
+
+	executions := L.NewTable()
+	hosts := L.NewTable()
+	byHost := L.NewTable()
+	for _, e := range backend.Executions {
+		etb := L.NewTable()
+		etb.RawSetString("task", lua.LString(e.Task))
+		etb.RawSetString("host", lua.LString(e.Host))
+		etb.RawSetString("backend", lua.LString(e.Backend))
+		etb.RawSetString("script", lua.LString(e.Script))
+		etb.RawSetString("command", toLStrings(L, e.Command))
+		etb.RawSetString("user", lua.LString(e.User))
+		etb.RawSetString("privileged", lua.LBool(e.Privileged))
+		etb.RawSetString("sudo", lua.LBool(e.Privileged || e.User != ""))
+		etb.RawSetString("pty", lua.LBool(e.Pty))
+		etb.RawSetString("ssh_options", toLStrings(L, e.SSHOptions))
+
+		executions.Append(etb)
+		hosts.Append(lua.LString(e.Host))
+		byHost.RawSetString(e.Host, etb)
+	}
+	tb.RawSetString("executions", executions)
+	tb.RawSetString("hosts", hosts)
+	tb.RawSetString("by_host", byHost)
+
+	hooks := L.NewTable()
+	for _, h := range backend.Hooks {
+		htb := L.NewTable()
+		htb.RawSetString("name", lua.LString(h.Name))
+		htb.RawSetString("script", lua.LString(h.Script))
+		hooks.Append(htb)
+	}
+	tb.RawSetString("hooks", hooks)
+
+	return tb
+}
+
+func toLStrings(L *lua.LState, values []string) *lua.LTable {
+	tb := L.NewTable()
+	for _, v := range values {
+		tb.Append(lua.LString(v))
+	}
+
+	return tb
+}
+
+func luaDeepEqual(a lua.LValue, b lua.LValue) bool {
+	atb, aok := a.(*lua.LTable)
+	btb, bok := b.(*lua.LTable)
+	if aok && bok {
+		equal := true
+		atb.ForEach(func(k lua.LValue, v lua.LValue) {
+			if !luaDeepEqual(v, btb.RawGet(k)) {
+				equal = false
+			}
+		})
+		btb.ForEach(func(k lua.LValue, v lua.LValue) {
+			if atb.RawGet(k) == lua.LNil {
+				equal = false
+			}
+		})
+		return equal
+	}
+
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a.(type) {
+	case lua.LString, lua.LNumber, lua.LBool, *lua.LNilType:
+		return a.String() == b.String()
+	}
+
+	return a == b
+}
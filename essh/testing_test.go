@@ -0,0 +1,64 @@
+package essh
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunTestsIsolatesFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "essh-testing-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestFiles(t, dir, map[string]string{
+		"a_test.lua": `
+shared = "a"
+task "tmp" { script = "echo" }
+deploy.description = "changed"
+
+test("defines globals and resources", function(t)
+  t:ok(shared == "a")
+end)
+`,
+		"b_test.lua": `
+test("does not see the other file", function(t)
+  t:ok(shared == nil, "shared global leaked")
+  t:ok(test ~= "user value", "test function is not defined")
+end)
+`,
+	})
+
+	L := newTestLState()
+	defer L.Close()
+
+	if err := L.DoString(`
+test = "user value"
+deploy = task "deploy" { description = "original", script = "echo" }
+`); err != nil {
+		t.Fatal(err)
+	}
+	LoadingConfig = false
+
+	suites := runTests(L, filepath.Join(dir, "ssh_config"), []string{filepath.Join(dir, "a_test.lua"), filepath.Join(dir, "b_test.lua")})
+	for _, suite := range suites {
+		for _, tc := range suite.Cases {
+			if !tc.Passed() {
+				t.Errorf("%s: %s failed: %v", suite.File, tc.Name, tc.Failures)
+			}
+		}
+	}
+
+	if Tasks["tmp"] != nil {
+		t.Errorf("the task defined in the test file must be removed")
+	}
+	if desc := Tasks["deploy"].Description; desc != "original" {
+		t.Errorf("expected the description to be restored but got %q", desc)
+	}
+	if v := L.GetGlobal("test").String(); v != "user value" {
+		t.Errorf("expected the global 'test' to be kept but got %q", v)
+	}
+}
@@ -0,0 +1,391 @@
+package essh
+
+import (
+	"fmt"
+	"github.com/yuin/gopher-lua"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Tunnel is a definition of a port forwarding that runs in background.
+type Tunnel struct {
+	Name         string
+	Description  string
+	Host         string
+	LocalAddress string
+	LocalPort    int
+	Remote       string
+	Registry     *Registry
+	Source       string
+	LValues      map[string]lua.LValue
+	Parent       *Tunnel
+	Child        *Tunnel
+}
+
+var Tunnels map[string]*Tunnel
+
+// TunnelStartTimeout is a duration to wait for detecting a failure of starting a tunnel.
+var TunnelStartTimeout = 1 * time.Second
+
+// TunnelStopTimeout is a duration to wait for a tunnel to exit by SIGTERM before it is killed.
+var TunnelStopTimeout = 3 * time.Second
+
+func NewTunnel() *Tunnel {
+	return &Tunnel{
+		LValues: map[string]lua.LValue{},
+	}
+}
+
+func (t *Tunnel) PIDFile() string {
+	return filepath.Join(t.Registry.TunnelsDir(), t.Name+".pid")
+}
+
+func (t *Tunnel) LogFile() string {
+	return filepath.Join(t.Registry.TunnelsDir(), t.Name+".log")
+}
+
+func (t *Tunnel) SSHConfigFile() string {
+	return filepath.Join(t.Registry.TunnelsDir(), t.Name+".ssh_config")
+}
+
+// Forward returns a value of the ssh -L option like "15432:db.internal:5432".
+func (t *Tunnel) Forward() string {
+	forward := fmt.Sprintf("%d:%s", t.LocalPort, t.Remote)
+	if t.LocalAddress != "" {
+		forward = t.LocalAddress + ":" + forward
+	}
+
+	return forward
+}
+
+func (t *Tunnel) Validate() error {
+	if t.Host == "" {
+		return fmt.Errorf("tunnel '%s' requires 'host'.", t.Name)
+	}
+
+	if Hosts[t.Host] == nil {
+		return fmt.Errorf("tunnel '%s' uses the host '%s' that is not defined.", t.Name, t.Host)
+	}
+
+	if t.LocalPort <= 0 || t.LocalPort > 65535 {
+		return fmt.Errorf("tunnel '%s' requires a valid 'local_port'.", t.Name)
+	}
+
+	if t.Remote == "" || !strings.Contains(t.Remote, ":") {
+		return fmt.Errorf("tunnel '%s' requires 'remote' like 'host:port'.", t.Name)
+	}
+
+	return nil
+}
+
+// PID returns the process id of the running tunnel. If the tunnel is not running, it returns 0.
+func (t *Tunnel) PID() int {
+	b, err := ioutil.ReadFile(t.PIDFile())
+	if err != nil {
+		return 0
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return 0
+	}
+
+	if err := process.Signal(syscall.Signal(0)); err != nil {
+		return 0
+	}
+
+	if !isTunnelProcess(pid, t.SSHConfigFile()) {
+		return 0
+	}
+
+	return pid
+}
+
+// Start runs ssh with the port forwarding in background and records the PID file.
+func (t *Tunnel) Start(sshConfigPath string) (int, error) {
+	if err := t.Validate(); err != nil {
+		return 0, err
+	}
+
+	if pid := t.PID(); pid != 0 {
+		return 0, fmt.Errorf("tunnel '%s' is already running. (pid: %d)", t.Name, pid)
+	}
+
+	if err := os.MkdirAll(t.Registry.TunnelsDir(), os.FileMode(0755)); err != nil {
+		return 0, err
+	}
+
+	// the background process keeps using the ssh config after essh exits.
+	content, err := ioutil.ReadFile(sshConfigPath)
+	if err != nil {
+		return 0, err
+	}
+	if err := ioutil.WriteFile(t.SSHConfigFile(), content, 0600); err != nil {
+		return 0, err
+	}
+
+	logFile, err := os.OpenFile(t.LogFile(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command("ssh",
+		"-F", t.SSHConfigFile(),
+		"-N",
+		"-o", "ExitOnForwardFailure=yes",
+		"-L", t.Forward(),
+		t.Host,
+	)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	detachTunnelProcess(cmd)
+
+	if debugFlag {
+		fmt.Printf("[essh debug] real ssh command: %v \n", cmd.Args)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	// detect an immediate failure like the port is already used.
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-exited:
+		os.Remove(t.SSHConfigFile())
+		log, _ := ioutil.ReadFile(t.LogFile())
+		return 0, fmt.Errorf("tunnel '%s' exited: %v: %s", t.Name, err, strings.TrimSpace(string(log)))
+	case <-time.After(TunnelStartTimeout):
+	}
+
+	pid := cmd.Process.Pid
+	if err := ioutil.WriteFile(t.PIDFile(), []byte(fmt.Sprintf("%d\n", pid)), 0644); err != nil {
+		return 0, err
+	}
+
+	return pid, nil
+}
+
+func (t *Tunnel) Stop() error {
+	pid := t.PID()
+	if pid == 0 {
+		os.Remove(t.PIDFile())
+		return fmt.Errorf("tunnel '%s' is not running.", t.Name)
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+
+	// ssh can close the connection by SIGTERM. it is killed only if it does not exit in time.
+	if err := process.Signal(syscall.SIGTERM); err != nil {
+		if err := process.Kill(); err != nil {
+			return err
+		}
+	}
+
+	for deadline := time.Now().Add(TunnelStopTimeout); process.Signal(syscall.Signal(0)) == nil; {
+		if time.Now().After(deadline) {
+			if err := process.Kill(); err != nil {
+				return err
+			}
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	os.Remove(t.PIDFile())
+	os.Remove(t.SSHConfigFile())
+
+	return nil
+}
+
+func GetTunnelsOrderByName() []*Tunnel {
+	names := []string{}
+	for name, _ := range Tunnels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tunnels := []*Tunnel{}
+	for _, name := range names {
+		tunnels = append(tunnels, Tunnels[name])
+	}
+
+	return tunnels
+}
+
+func esshTunnel(L *lua.LState) int {
+	name := L.CheckString(1)
+	if L.GetTop() == 1 {
+		// object or DSL style
+		t := registerTunnel(L, name)
+		L.Push(newLTunnel(L, t))
+
+		return 1
+	} else if L.GetTop() == 2 {
+		// function style
+		tb := L.CheckTable(2)
+		t := registerTunnel(L, name)
+		setupTunnel(L, t, tb)
+		L.Push(newLTunnel(L, t))
+
+		return 1
+	}
+
+	argumentsError(L, "tunnel", "requires 1 or 2 arguments.")
+
+	return 0
+}
+
+func registerTunnel(L *lua.LState, name string) *Tunnel {
+	if debugFlag {
+		fmt.Printf("[essh debug] register tunnel: %s (%s)\n", name, luaSourcePosition(L))
+	}
+
+	t := NewTunnel()
+	t.Name = name
+	t.Registry = CurrentRegistry
+	t.Source = luaSourcePosition(L)
+
+	if tunnel := Tunnels[t.Name]; tunnel != nil {
+		// detect same name tunnel
+		t.Child = tunnel
+		tunnel.Parent = t
+	}
+
+	Tunnels[t.Name] = t
+
+	return t
+}
+
+func setupTunnel(L *lua.LState, t *Tunnel, config *lua.LTable) {
+	config.ForEach(func(k, v lua.LValue) {
+		if kstr, ok := toString(k); ok {
+			updateTunnel(L, t, kstr, v)
+		}
+	})
+}
+
+func updateTunnel(L *lua.LState, t *Tunnel, key string, value lua.LValue) {
+	t.LValues[key] = value
+
+	switch key {
+	case "description":
+		if descStr, ok := toString(value); ok {
+			t.Description = descStr
+		} else {
+			fieldError(L, "tunnel", t.Name, t.Source, key, "a string", value)
+		}
+	case "host":
+		if hostStr, ok := toString(value); ok {
+			t.Host = hostStr
+		} else {
+			fieldError(L, "tunnel", t.Name, t.Source, key, "a string", value)
+		}
+	case "local_address":
+		if addrStr, ok := toString(value); ok {
+			t.LocalAddress = addrStr
+		} else {
+			fieldError(L, "tunnel", t.Name, t.Source, key, "a string", value)
+		}
+	case "local_port":
+		if port, ok := toFloat64(value); ok {
+			t.LocalPort = int(port)
+		} else {
+			fieldError(L, "tunnel", t.Name, t.Source, key, "a number", value)
+		}
+	case "remote":
+		if remoteStr, ok := toString(value); ok {
+			t.Remote = remoteStr
+		} else {
+			fieldError(L, "tunnel", t.Name, t.Source, key, "a string like 'host:port'", value)
+		}
+	default:
+		fieldError(L, "tunnel", t.Name, t.Source, key, "", value)
+	}
+}
+
+const LTunnelClass = "Tunnel*"
+
+func registerTunnelClass(L *lua.LState) {
+	mt := L.NewTypeMetatable(LTunnelClass)
+	mt.RawSetString("__call", L.NewFunction(tunnelCall))
+	mt.RawSetString("__index", L.NewFunction(tunnelIndex))
+	mt.RawSetString("__newindex", L.NewFunction(tunnelNewindex))
+}
+
+func newLTunnel(L *lua.LState, tunnel *Tunnel) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = tunnel
+	L.SetMetatable(ud, L.GetTypeMetatable(LTunnelClass))
+	return ud
+}
+
+func checkTunnel(L *lua.LState) *Tunnel {
+	ud := L.CheckUserData(1)
+	if v, ok := ud.Value.(*Tunnel); ok {
+		return v
+	}
+	L.ArgError(1, "Tunnel object expected")
+	return nil
+}
+
+func tunnelCall(L *lua.LState) int {
+	tunnel := checkTunnel(L)
+	tb := L.CheckTable(2)
+
+	setupTunnel(L, tunnel, tb)
+
+	L.Push(L.CheckUserData(1))
+	return 1
+}
+
+func tunnelIndex(L *lua.LState) int {
+	tunnel := checkTunnel(L)
+	index := L.CheckString(2)
+
+	if index == "name" {
+		L.Push(L.NewFunction(func(L *lua.LState) int {
+			L.Push(lua.LString(tunnel.Name))
+			return 1
+		}))
+		return 1
+	}
+
+	v, ok := tunnel.LValues[index]
+	if v == nil || !ok {
+		v = lua.LNil
+	}
+
+	L.Push(v)
+	return 1
+}
+
+func tunnelNewindex(L *lua.LState) int {
+	tunnel := checkTunnel(L)
+	index := L.CheckString(2)
+	value := L.CheckAny(3)
+
+	updateTunnel(L, tunnel, index, value)
+
+	return 0
+}
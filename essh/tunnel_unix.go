@@ -0,0 +1,28 @@
+//go:build !windows
+// +build !windows
+
+package essh
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// detachTunnelProcess runs the tunnel in a new session, so that it keeps running after the terminal of essh is closed.
+func detachTunnelProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
+
+// isTunnelProcess reports whether the process is the ssh of the tunnel by the command line.
+// The pid in the PID file may be reused by another process after the tunnel exited.
+func isTunnelProcess(pid int, sshConfigFile string) bool {
+	out, err := exec.Command("ps", "-p", strconv.Itoa(pid), "-o", "command=").Output()
+	if err != nil {
+		return false
+	}
+
+	command := string(out)
+	return strings.Contains(command, "ssh") && strings.Contains(command, sshConfigFile)
+}
@@ -0,0 +1,16 @@
+package essh
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detachTunnelProcess runs the tunnel in a new process group, so that it is not stopped by Ctrl-C to essh.
+func detachTunnelProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// isTunnelProcess always reports true on Windows, because the command line of a process can't be got easily.
+func isTunnelProcess(pid int, sshConfigFile string) bool {
+	return true
+}
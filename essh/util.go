@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"regexp"
 	"runtime"
 	"strings"
 )
@@ -28,10 +29,25 @@ func EnvKeyEscape(s string) string {
 	return strings.Replace(strings.Replace(s, "-", "_", -1), ".", "_", -1)
 }
 
+var envKeyRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func IsValidEnvKey(s string) bool {
+	return envKeyRegexp.MatchString(s)
+}
+
 func ColonEscape(s string) string {
 	return strings.Replace(s, ":", "\\:", -1)
 }
 
+// TrimNewline removes a trailing newline ("\n" or "\r\n") like the one that 'echo' appends.
+func TrimNewline(s string) string {
+	if strings.HasSuffix(s, "\r\n") {
+		return s[:len(s)-2]
+	}
+
+	return strings.TrimSuffix(s, "\n")
+}
+
 func GetContentFromPath(shellPath string) ([]byte, error) {
 	var scriptContent []byte
 	if strings.HasPrefix(shellPath, "http://") || strings.HasPrefix(shellPath, "https://") {
@@ -72,3 +88,56 @@ func GetContentFromPath(shellPath string) ([]byte, error) {
 
 	return scriptContent, nil
 }
+
+// sshOptionsWithArg are the ssh options that take an argument.
+const sshOptionsWithArg = "BbcDEeFIiJLlmOoPpQRSWw"
+
+// ParseSSHDestination finds the destination host in the ssh command line arguments.
+// It returns the host name, the index of the destination in the args and
+// whether the args have a command after the destination.
+// If the destination is not found, the index is -1.
+func ParseSSHDestination(args []string) (string, int, bool) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		if arg == "--" {
+			if i+1 < len(args) {
+				return sshDestinationHost(args[i+1]), i + 1, i+2 < len(args)
+			}
+			break
+		}
+
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			return sshDestinationHost(arg), i, i+1 < len(args)
+		}
+
+		// combined flags like "-tt" or an option with a value like "-p22".
+		for j := 1; j < len(arg); j++ {
+			if strings.IndexByte(sshOptionsWithArg, arg[j]) >= 0 {
+				if j == len(arg)-1 {
+					// the value is the next arg.
+					i++
+				}
+				break
+			}
+		}
+	}
+
+	return "", -1, false
+}
+
+// sshDestinationHost gets the host from "[user@]host" or "ssh://[user@]host[:port]".
+func sshDestinationHost(destination string) string {
+	if strings.HasPrefix(destination, "ssh://") {
+		destination = strings.TrimPrefix(destination, "ssh://")
+		if idx := strings.LastIndex(destination, ":"); idx >= 0 && !strings.Contains(destination[idx:], "]") {
+			destination = destination[:idx]
+		}
+	}
+
+	if idx := strings.LastIndex(destination, "@"); idx >= 0 {
+		destination = destination[idx+1:]
+	}
+
+	return destination
+}
@@ -0,0 +1,55 @@
+package essh
+
+import (
+	"testing"
+)
+
+func TestParseSSHDestination(t *testing.T) {
+	cases := []struct {
+		args       []string
+		host       string
+		index      int
+		hasCommand bool
+	}{
+		{args: []string{"web01"}, host: "web01", index: 0},
+		{args: []string{"web01", "uptime"}, host: "web01", index: 0, hasCommand: true},
+		{args: []string{"-p", "2222", "web01", "uptime"}, host: "web01", index: 2, hasCommand: true},
+		{args: []string{"-p2222", "web01"}, host: "web01", index: 1},
+		{args: []string{"-tt", "web01"}, host: "web01", index: 1},
+		{args: []string{"-tl", "user", "web01"}, host: "web01", index: 2},
+		{args: []string{"-A", "-o", "ForwardAgent=yes", "user@web01"}, host: "web01", index: 3},
+		{args: []string{"ssh://user@web01:2222", "ls"}, host: "web01", index: 0, hasCommand: true},
+		{args: []string{"ssh://web01"}, host: "web01", index: 0},
+		{args: []string{"-v", "--", "web01", "ls"}, host: "web01", index: 2, hasCommand: true},
+		{args: []string{"-v", "--"}, host: "", index: -1},
+		{args: []string{"-p", "2222"}, host: "", index: -1},
+		{args: []string{}, host: "", index: -1},
+	}
+
+	for _, c := range cases {
+		host, index, hasCommand := ParseSSHDestination(c.args)
+		if host != c.host || index != c.index || hasCommand != c.hasCommand {
+			t.Errorf("%v: expected (%q, %d, %v) but got (%q, %d, %v)", c.args, c.host, c.index, c.hasCommand, host, index, hasCommand)
+		}
+	}
+}
+
+func TestTrimNewline(t *testing.T) {
+	cases := []struct {
+		s        string
+		expected string
+	}{
+		{s: "secret\n", expected: "secret"},
+		{s: "secret\r\n", expected: "secret"},
+		{s: "secret\n\n", expected: "secret\n"},
+		{s: "secret", expected: "secret"},
+		{s: "secret\r", expected: "secret\r"},
+		{s: "", expected: ""},
+	}
+
+	for _, c := range cases {
+		if actual := TrimNewline(c.s); actual != c.expected {
+			t.Errorf("TrimNewline(%q): expected %q but got %q", c.s, c.expected, actual)
+		}
+	}
+}
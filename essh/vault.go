@@ -0,0 +1,201 @@
+package essh
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kohkimakimoto/essh/support/vault"
+	"github.com/yuin/gopher-lua"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// LoadVaultData reads a YAML or JSON file that is entirely encrypted or has encrypted values.
+// The values are decrypted after the file is parsed, so only whole string values are decrypted.
+// It returns the decrypted data and the plaintexts that should be masked.
+func LoadVaultData(path string, keyEnv string, keyFile string) (interface{}, []string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	content := string(b)
+	entirelyEncrypted := vault.IsEncrypted(content)
+	if entirelyEncrypted {
+		key, err := LoadVaultKey(keyEnv, keyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		b, err = vault.Decrypt(key, content)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%v: %s", err, path)
+		}
+	}
+
+	var data interface{}
+	if isYAMLVaultFile(path) {
+		err = yaml.Unmarshal(b, &data)
+	} else {
+		err = json.Unmarshal(b, &data)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't parse '%s': %v", path, err)
+	}
+
+	if entirelyEncrypted {
+		// all the values of the encrypted file are masked.
+		return data, stringLeaves(data), nil
+	}
+
+	if !vault.ContainsEncrypted(data) {
+		return data, []string{}, nil
+	}
+
+	key, err := LoadVaultKey(keyEnv, keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, plaintexts, err := vault.DecryptAll(key, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%v: %s", err, path)
+	}
+
+	return data, plaintexts, nil
+}
+
+// isYAMLVaultFile reports whether the file is YAML by the extension. It strips the encrypted file's extension like "secrets.yml.enc".
+func isYAMLVaultFile(path string) bool {
+	ext := filepath.Ext(path)
+	if ext == ".enc" || ext == ".vault" {
+		ext = filepath.Ext(strings.TrimSuffix(path, ext))
+	}
+
+	return ext == ".yml" || ext == ".yaml"
+}
+
+// DecryptFile returns the decrypted content of a file for --decrypt-file.
+// A file that has encrypted values is printed in the same format with the decrypted values.
+func DecryptFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	if vault.IsEncrypted(string(b)) {
+		key, err := LoadVaultKey("", "")
+		if err != nil {
+			return "", err
+		}
+
+		b, err := vault.Decrypt(key, string(b))
+		if err != nil {
+			return "", fmt.Errorf("%v: %s", err, path)
+		}
+		return string(b), nil
+	}
+
+	data, _, err := LoadVaultData(path, "", "")
+	if err != nil {
+		return "", err
+	}
+
+	if isYAMLVaultFile(path) {
+		b, err = yaml.Marshal(data)
+	} else {
+		b, err = json.MarshalIndent(data, "", "  ")
+		b = append(b, '\n')
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func vaultLoader(L *lua.LState) int {
+	tb := L.NewTable()
+	L.SetFuncs(tb, map[string]lua.LGFunction{
+		"load":    vaultLoad,
+		"decrypt": vaultDecrypt,
+	})
+	L.Push(tb)
+
+	return 1
+}
+
+func vaultOptions(L *lua.LState, n int) (string, string) {
+	if L.GetTop() < n {
+		return "", ""
+	}
+
+	options := L.CheckTable(n)
+	keyEnv, _ := toString(options.RawGetString("key_env"))
+	keyFile, _ := toString(options.RawGetString("key_file"))
+
+	return keyEnv, keyFile
+}
+
+func vaultLoad(L *lua.LState) int {
+	path := L.CheckString(1)
+	keyEnv, keyFile := vaultOptions(L, 2)
+
+	data, plaintexts, err := LoadVaultData(path, keyEnv, keyFile)
+	if err != nil {
+		L.RaiseError("%v", err)
+	}
+
+	// the decrypted values are masked in the outputs.
+	for i, plaintext := range plaintexts {
+		Secrets.Add(fmt.Sprintf("vault:%s:%d", path, i), plaintext)
+	}
+
+	L.Push(toLValue(L, data))
+	return 1
+}
+
+func vaultDecrypt(L *lua.LState) int {
+	data := L.CheckString(1)
+	keyEnv, keyFile := vaultOptions(L, 2)
+
+	key, err := LoadVaultKey(keyEnv, keyFile)
+	if err != nil {
+		L.RaiseError("%v", err)
+	}
+
+	b, err := vault.Decrypt(key, data)
+	if err != nil {
+		L.RaiseError("%v", err)
+	}
+
+	// the secret is named by the ciphertext, because the name must not have the plaintext.
+	Secrets.Add("vault:"+data, string(b))
+
+	L.Push(lua.LString(string(b)))
+	return 1
+}
+
+func stringLeaves(data interface{}) []string {
+	leaves := []string{}
+
+	switch v := data.(type) {
+	case string:
+		leaves = append(leaves, v)
+	case []interface{}:
+		for _, item := range v {
+			leaves = append(leaves, stringLeaves(item)...)
+		}
+	case map[interface{}]interface{}:
+		for _, item := range v {
+			leaves = append(leaves, stringLeaves(item)...)
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			leaves = append(leaves, stringLeaves(item)...)
+		}
+	}
+
+	return leaves
+}
@@ -0,0 +1,40 @@
+package essh
+
+import (
+	"github.com/kohkimakimoto/essh/support/vault"
+	"github.com/yuin/gopher-lua"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestVaultDecryptRegistersSecret(t *testing.T) {
+	L := newTestLState()
+	defer L.Close()
+
+	key := os.Getenv("ESSH_VAULT_KEY")
+	os.Setenv("ESSH_VAULT_KEY", "test-passphrase")
+	defer os.Setenv("ESSH_VAULT_KEY", key)
+
+	data, err := vault.Encrypt([]byte("test-passphrase"), []byte("p@ssw0rd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	L.SetGlobal("data", lua.LString(data))
+	if err := L.DoString(`password = require("vault").decrypt(data)`); err != nil {
+		t.Fatal(err)
+	}
+
+	if password := L.GetGlobal("password").String(); password != "p@ssw0rd" {
+		t.Errorf("expected the decrypted value but got %q", password)
+	}
+	if masked := Secrets.Mask("password=p@ssw0rd"); masked != "password="+SecretMask {
+		t.Errorf("expected the decrypted value to be masked but got %q", masked)
+	}
+	for name := range Secrets.values {
+		if strings.Contains(name, "p@ssw0rd") {
+			t.Errorf("the name of the secret must not have the plaintext: %s", name)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package essh
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/kohkimakimoto/essh/support/color"
+	"github.com/kohkimakimoto/essh/support/helper"
+	"github.com/yuin/gopher-lua"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WatchOutputWidth is the max width of the last output line in the watch mode table.
+var WatchOutputWidth = 80
+
+// WatchStatus is the last result of the command with a host in the watch mode.
+type WatchStatus struct {
+	Host     *Host
+	ExitCode int
+	Output   string
+	Changed  bool
+	Runs     int
+}
+
+// LastLine returns the last non-empty line of the output.
+func (s *WatchStatus) LastLine() string {
+	lines := strings.Split(strings.TrimRight(s.Output, "\r\n"), "\n")
+	line := strings.TrimSpace(lines[len(lines)-1])
+
+	if runes := []rune(line); len(runes) > WatchOutputWidth {
+		line = string(runes[:WatchOutputWidth-3]) + "..."
+	}
+
+	return line
+}
+
+// ParseWatchInterval parses an interval like "2s", "1m" or "5" (seconds).
+func ParseWatchInterval(interval string) (time.Duration, error) {
+	value := interval
+	if n, err := strconv.Atoi(interval); err == nil {
+		value = fmt.Sprintf("%ds", n)
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("--watch requires a positive interval like '2s' or '1m' but got '%s'.", interval)
+	}
+
+	return d, nil
+}
+
+// runWatch runs the task with the hosts periodically and renders the results as a table.
+// Each run goes through the same path as runTask, so that the hooks work as usual.
+// Only the first run is written to the audit log and the history, so that the watch session doesn't flood them.
+// It runs until the process is interrupted.
+func runWatch(config string, task *Task, L *lua.LState, command string, interval time.Duration) error {
+	statuses := map[string]*WatchStatus{}
+	for first := true; ; first = false {
+		var hosts []*Host
+		var scriptsErr error
+		runner := func(config string, task *Task, runHosts []*Host, result *TaskResult) error {
+			hosts = runHosts
+			scriptsErr = runWatchOnce(config, task, hosts, result, statuses)
+			return scriptsErr
+		}
+
+		err := runTaskWithScriptsRunner(config, task, []string{}, L, runner, first)
+		if hosts == nil {
+			// the task failed before running the scripts.
+			return err
+		}
+
+		renderWatch(os.Stdout, hosts, statuses, command, interval)
+		if err != nil && err != scriptsErr {
+			fmt.Fprint(os.Stderr, color.FgRB("essh error: %v\n", Secrets.Mask(err.Error())))
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+func runWatchOnce(config string, task *Task, hosts []*Host, result *TaskResult, statuses map[string]*WatchStatus) error {
+	outputs := map[string]*bytes.Buffer{}
+	for _, host := range hosts {
+		outputs[host.Name] = new(bytes.Buffer)
+		if statuses[host.Name] == nil {
+			statuses[host.Name] = &WatchStatus{Host: host}
+		}
+	}
+
+	err := runTaskScriptsWithOutputs(config, task, hosts, result, func(host *Host) io.Writer {
+		return outputs[host.Name]
+	})
+
+	for _, hostResult := range result.HostResults {
+		status := statuses[hostResult.Host.Name]
+		output := outputs[hostResult.Host.Name].String()
+
+		status.Changed = status.Runs > 0 && status.Output != output
+		status.Output = output
+		status.ExitCode = hostResult.ExitCode
+		status.Runs++
+	}
+
+	return err
+}
+
+func renderWatch(w io.Writer, hosts []*Host, statuses map[string]*WatchStatus, command string, interval time.Duration) {
+	// clear the screen like the watch command.
+	fmt.Fprint(w, "\033[H\033[2J")
+	fmt.Fprintf(w, "Every %s: %s    %s\n\n", interval, Secrets.Mask(command), time.Now().Format("2006-01-02 15:04:05"))
+
+	tb := helper.NewPlainTable(w)
+	tb.SetHeader([]string{"HOST", "EXIT", "CHANGED", "LAST OUTPUT"})
+	for _, host := range hosts {
+		status := statuses[host.Name]
+		changed := ""
+		if status.Changed {
+			changed = "*"
+		}
+		tb.Append([]string{host.Name, fmt.Sprintf("%d", status.ExitCode), changed, status.LastLine()})
+	}
+	tb.Render()
+}
@@ -0,0 +1,226 @@
+// knownhosts reads and writes OpenSSH known_hosts files.
+// It supports plain and hashed ("|1|salt|hash") host names, and detects changed host keys.
+package knownhosts
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrUnknown = errors.New("host key is unknown")
+	ErrChanged = errors.New("host key has changed")
+)
+
+// Entry is a line of known_hosts like "example.com,192.168.0.1 ssh-ed25519 AAAA...".
+type Entry struct {
+	Marker  string
+	Hosts   []string
+	KeyType string
+	Key     string
+	Comment string
+}
+
+func (e *Entry) String() string {
+	fields := []string{}
+	if e.Marker != "" {
+		fields = append(fields, e.Marker)
+	}
+	fields = append(fields, strings.Join(e.Hosts, ","), e.KeyType, e.Key)
+	if e.Comment != "" {
+		fields = append(fields, e.Comment)
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// Match reports whether the entry is for the host. The host is like "example.com" or "[example.com]:2222".
+func (e *Entry) Match(host string) bool {
+	for _, pattern := range e.Hosts {
+		if strings.HasPrefix(pattern, "|1|") {
+			if matchHashed(pattern, host) {
+				return true
+			}
+		} else if pattern == host {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchHashed(pattern string, host string) bool {
+	parts := strings.Split(pattern, "|")
+	if len(parts) != 4 {
+		return false
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+
+	hash, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+
+	return hmac.Equal(mac.Sum(nil), hash)
+}
+
+// File is a content of known_hosts. It keeps comments and unsupported lines as they are.
+type File struct {
+	lines   []string
+	entries []*Entry
+}
+
+func Parse(r io.Reader) (*File, error) {
+	f := &File{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		entry, err := ParseLine(line)
+		if err != nil {
+			return nil, err
+		}
+
+		f.lines = append(f.lines, line)
+		f.entries = append(f.entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// ParseLine parses a line of known_hosts. If the line is a comment or blank, it returns nil.
+func ParseLine(line string) (*Entry, error) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return nil, nil
+	}
+
+	fields := strings.Fields(trimmed)
+	entry := &Entry{}
+	if strings.HasPrefix(fields[0], "@") {
+		entry.Marker = fields[0]
+		fields = fields[1:]
+	}
+
+	if len(fields) < 3 {
+		return nil, fmt.Errorf("invalid known_hosts line: %s", line)
+	}
+
+	entry.Hosts = strings.Split(fields[0], ",")
+	entry.KeyType = fields[1]
+	entry.Key = fields[2]
+	if len(fields) > 3 {
+		entry.Comment = strings.Join(fields[3:], " ")
+	}
+
+	return entry, nil
+}
+
+// Lookup returns the entries of the host. The entries that have markers like "@revoked" are excluded.
+func (f *File) Lookup(host string) []*Entry {
+	entries := []*Entry{}
+	for _, entry := range f.entries {
+		if entry != nil && entry.Marker == "" && entry.Match(host) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries
+}
+
+// Check compares the scanned keys with the recorded keys of the host.
+// It returns ErrUnknown if the host is not recorded, and ErrChanged if a key of the same type differs
+// or the host does not have any recorded key types.
+func (f *File) Check(host string, keys []*Entry) error {
+	recorded := f.Lookup(host)
+	if len(recorded) == 0 {
+		return ErrUnknown
+	}
+
+	matchedType := false
+	for _, key := range keys {
+		for _, r := range recorded {
+			if r.KeyType != key.KeyType {
+				continue
+			}
+			matchedType = true
+			if r.Key != key.Key {
+				return ErrChanged
+			}
+		}
+	}
+
+	if !matchedType {
+		return ErrChanged
+	}
+
+	return nil
+}
+
+// Replace removes the entries of the host and adds the keys.
+func (f *File) Replace(host string, keys []*Entry) {
+	lines := []string{}
+	entries := []*Entry{}
+	for i, entry := range f.entries {
+		if entry != nil && entry.Marker == "" && entry.Match(host) {
+			continue
+		}
+		lines = append(lines, f.lines[i])
+		entries = append(entries, entry)
+	}
+
+	sorted := make([]*Entry, len(keys))
+	copy(sorted, keys)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].KeyType < sorted[j].KeyType
+	})
+
+	for _, key := range sorted {
+		entry := &Entry{Hosts: []string{host}, KeyType: key.KeyType, Key: key.Key}
+		lines = append(lines, entry.String())
+		entries = append(entries, entry)
+	}
+
+	f.lines = lines
+	f.entries = entries
+}
+
+func (f *File) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	for _, line := range f.lines {
+		m, err := io.WriteString(w, line+"\n")
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// HostPattern returns a host name that is used in known_hosts like "example.com" or "[example.com]:2222".
+func HostPattern(hostname string, port string) string {
+	if port == "" || port == "22" {
+		return hostname
+	}
+
+	return "[" + hostname + "]:" + port
+}
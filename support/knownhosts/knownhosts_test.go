@@ -0,0 +1,112 @@
+package knownhosts
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+var testContent = `# comment
+example.com,192.168.0.1 ssh-ed25519 AAAAkey1
+[example.com]:2222 ssh-rsa AAAAkey2 user@host
+@revoked revoked.example.com ssh-ed25519 AAAAkey3
+`
+
+func TestParseAndLookup(t *testing.T) {
+	f, err := Parse(strings.NewReader(testContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entries := f.Lookup("192.168.0.1"); len(entries) != 1 || entries[0].Key != "AAAAkey1" {
+		t.Errorf("unexpected entries: %v", entries)
+	}
+
+	if entries := f.Lookup("[example.com]:2222"); len(entries) != 1 || entries[0].Comment != "user@host" {
+		t.Errorf("unexpected entries: %v", entries)
+	}
+
+	if entries := f.Lookup("revoked.example.com"); len(entries) != 0 {
+		t.Errorf("revoked entries must be excluded: %v", entries)
+	}
+}
+
+func TestMatchHashed(t *testing.T) {
+	salt := []byte("0123456789abcdefghij")
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte("hashed.example.com"))
+	pattern := "|1|" + base64.StdEncoding.EncodeToString(salt) + "|" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	entry := &Entry{Hosts: []string{pattern}, KeyType: "ssh-ed25519", Key: "AAAA"}
+	if !entry.Match("hashed.example.com") {
+		t.Error("expected to match the hashed host")
+	}
+	if entry.Match("other.example.com") {
+		t.Error("expected not to match the other host")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	f, err := Parse(strings.NewReader(testContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	same := []*Entry{{KeyType: "ssh-ed25519", Key: "AAAAkey1"}, {KeyType: "ecdsa-sha2-nistp256", Key: "AAAAother"}}
+	if err := f.Check("example.com", same); err != nil {
+		t.Errorf("expected nil but got %v", err)
+	}
+
+	changed := []*Entry{{KeyType: "ssh-ed25519", Key: "AAAAchanged"}}
+	if err := f.Check("example.com", changed); err != ErrChanged {
+		t.Errorf("expected ErrChanged but got %v", err)
+	}
+
+	otherType := []*Entry{{KeyType: "ssh-dss", Key: "AAAAkey1"}}
+	if err := f.Check("example.com", otherType); err != ErrChanged {
+		t.Errorf("expected ErrChanged but got %v", err)
+	}
+
+	if err := f.Check("unknown.example.com", same); err != ErrUnknown {
+		t.Errorf("expected ErrUnknown but got %v", err)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	f, err := Parse(strings.NewReader(testContent))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Replace("[example.com]:2222", []*Entry{{KeyType: "ssh-rsa", Key: "AAAAnew"}, {KeyType: "ssh-ed25519", Key: "AAAAnew2"}})
+
+	var b bytes.Buffer
+	if _, err := f.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# comment
+example.com,192.168.0.1 ssh-ed25519 AAAAkey1
+@revoked revoked.example.com ssh-ed25519 AAAAkey3
+[example.com]:2222 ssh-ed25519 AAAAnew2
+[example.com]:2222 ssh-rsa AAAAnew
+`
+	if b.String() != expected {
+		t.Errorf("unexpected content:\n%s", b.String())
+	}
+}
+
+func TestHostPattern(t *testing.T) {
+	if p := HostPattern("example.com", ""); p != "example.com" {
+		t.Errorf("unexpected pattern: %s", p)
+	}
+	if p := HostPattern("example.com", "22"); p != "example.com" {
+		t.Errorf("unexpected pattern: %s", p)
+	}
+	if p := HostPattern("example.com", "2222"); p != "[example.com]:2222" {
+		t.Errorf("unexpected pattern: %s", p)
+	}
+}
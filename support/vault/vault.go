@@ -0,0 +1,188 @@
+// vault provides a simple symmetric encryption that is used by the secrets and the encrypted config values.
+// A data is encrypted by AES-256-GCM with a key that is derived from a passphrase and a random salt by scrypt,
+// and it is encoded as a string like "$ESSH_VAULT;1;<base64 of salt, nonce and ciphertext>".
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"golang.org/x/crypto/scrypt"
+	"io"
+	"strings"
+)
+
+const Header = "$ESSH_VAULT;1;"
+
+// SaltSize is the size of the random salt that is stored at the head of the encrypted data.
+const SaltSize = 16
+
+// DeriveKey derives a 32 bytes key for AES-256 from a passphrase and a salt.
+func DeriveKey(passphrase []byte, salt []byte) ([]byte, error) {
+	return scrypt.Key(passphrase, salt, 32768, 8, 1, 32)
+}
+
+// IsEncrypted reports whether the string is an encrypted data.
+func IsEncrypted(s string) bool {
+	return strings.HasPrefix(strings.TrimSpace(s), Header)
+}
+
+// ContainsEncrypted reports whether the data that is decoded from YAML or JSON has encrypted string values.
+func ContainsEncrypted(data interface{}) bool {
+	switch v := data.(type) {
+	case string:
+		return IsEncrypted(v)
+	case []interface{}:
+		for _, item := range v {
+			if ContainsEncrypted(item) {
+				return true
+			}
+		}
+	case map[interface{}]interface{}:
+		for _, item := range v {
+			if ContainsEncrypted(item) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			if ContainsEncrypted(item) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// DecryptAll decrypts the encrypted string values in the data that is decoded from YAML or JSON.
+// It returns a copy of the data that has the decrypted values and the plaintexts of the values.
+func DecryptAll(passphrase []byte, data interface{}) (interface{}, []string, error) {
+	plaintexts := []string{}
+
+	var decrypt func(data interface{}) (interface{}, error)
+	decrypt = func(data interface{}) (interface{}, error) {
+		switch v := data.(type) {
+		case string:
+			if !IsEncrypted(v) {
+				return v, nil
+			}
+			plaintext, err := Decrypt(passphrase, v)
+			if err != nil {
+				return nil, err
+			}
+			plaintexts = append(plaintexts, string(plaintext))
+			return string(plaintext), nil
+		case []interface{}:
+			items := make([]interface{}, len(v))
+			for i, item := range v {
+				decrypted, err := decrypt(item)
+				if err != nil {
+					return nil, err
+				}
+				items[i] = decrypted
+			}
+			return items, nil
+		case map[interface{}]interface{}:
+			items := make(map[interface{}]interface{}, len(v))
+			for k, item := range v {
+				decrypted, err := decrypt(item)
+				if err != nil {
+					return nil, err
+				}
+				items[k] = decrypted
+			}
+			return items, nil
+		case map[string]interface{}:
+			items := make(map[string]interface{}, len(v))
+			for k, item := range v {
+				decrypted, err := decrypt(item)
+				if err != nil {
+					return nil, err
+				}
+				items[k] = decrypted
+			}
+			return items, nil
+		}
+
+		return data, nil
+	}
+
+	decrypted, err := decrypt(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return decrypted, plaintexts, nil
+}
+
+func Encrypt(passphrase []byte, plaintext []byte) (string, error) {
+	salt := make([]byte, SaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+
+	gcm, err := newGCM(passphrase, salt)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(append(salt, nonce...), nonce, plaintext, nil)
+
+	return Header + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func Decrypt(passphrase []byte, data string) ([]byte, error) {
+	data = strings.TrimSpace(data)
+	if !strings.HasPrefix(data, Header) {
+		return nil, errors.New("vault: the data is not encrypted by essh")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(data, Header))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < SaltSize {
+		return nil, errors.New("vault: the encrypted data is too short")
+	}
+
+	gcm, err := newGCM(passphrase, sealed[:SaltSize])
+	if err != nil {
+		return nil, err
+	}
+	sealed = sealed[SaltSize:]
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("vault: the encrypted data is too short")
+	}
+
+	nonce := sealed[:gcm.NonceSize()]
+	plaintext, err := gcm.Open(nil, nonce, sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("vault: failed to decrypt the data. the key may be wrong")
+	}
+
+	return plaintext, nil
+}
+
+func newGCM(passphrase []byte, salt []byte) (cipher.AEAD, error) {
+	key, err := DeriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,127 @@
+package vault
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEncryptAndDecrypt(t *testing.T) {
+	key := []byte("passphrase")
+
+	encrypted, err := Encrypt(key, []byte("secret value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same value is encrypted to a different data by the random salt and nonce.
+	encrypted2, err := Encrypt(key, []byte("secret value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encrypted == encrypted2 {
+		t.Errorf("expected different encrypted data but got the same '%s'", encrypted)
+	}
+
+	if !IsEncrypted(encrypted) {
+		t.Errorf("expected encrypted data but got '%s'", encrypted)
+	}
+
+	decrypted, err := Decrypt(key, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(decrypted) != "secret value" {
+		t.Errorf("expected 'secret value' but got '%s'", decrypted)
+	}
+}
+
+func TestDecryptWithWrongKey(t *testing.T) {
+	encrypted, err := Encrypt([]byte("passphrase"), []byte("secret value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Decrypt([]byte("wrong"), encrypted); err == nil {
+		t.Error("expected an error but got nil")
+	}
+
+	if _, err := Decrypt([]byte("passphrase"), "plain text"); err == nil {
+		t.Error("expected an error but got nil")
+	}
+}
+
+func TestDecryptAll(t *testing.T) {
+	key := []byte("passphrase")
+
+	encrypted, err := Encrypt(key, []byte("secret value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name       string
+		data       interface{}
+		expected   interface{}
+		plaintexts []string
+	}{
+		{
+			name:       "yaml map",
+			data:       map[interface{}]interface{}{"user": "foo", "password": encrypted},
+			expected:   map[interface{}]interface{}{"user": "foo", "password": "secret value"},
+			plaintexts: []string{"secret value"},
+		},
+		{
+			name:       "json nested",
+			data:       map[string]interface{}{"db": []interface{}{map[string]interface{}{"password": encrypted, "port": 5432.0}}},
+			expected:   map[string]interface{}{"db": []interface{}{map[string]interface{}{"password": "secret value", "port": 5432.0}}},
+			plaintexts: []string{"secret value"},
+		},
+		{
+			// only the whole string value is decrypted.
+			name:       "embedded in a string",
+			data:       []interface{}{"prefix " + encrypted},
+			expected:   []interface{}{"prefix " + encrypted},
+			plaintexts: []string{},
+		},
+		{
+			// keys are not decrypted.
+			name:       "encrypted key",
+			data:       map[string]interface{}{encrypted: "foo"},
+			expected:   map[string]interface{}{encrypted: "foo"},
+			plaintexts: []string{},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if ContainsEncrypted(c.data) != (len(c.plaintexts) > 0) {
+				t.Errorf("unexpected ContainsEncrypted result for %v", c.data)
+			}
+
+			decrypted, plaintexts, err := DecryptAll(key, c.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(decrypted, c.expected) {
+				t.Errorf("expected %v but got %v", c.expected, decrypted)
+			}
+
+			if !reflect.DeepEqual(plaintexts, c.plaintexts) {
+				t.Errorf("expected plaintexts %v but got %v", c.plaintexts, plaintexts)
+			}
+		})
+	}
+}
+
+func TestDecryptAllWithWrongKey(t *testing.T) {
+	encrypted, err := Encrypt([]byte("passphrase"), []byte("secret value"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := DecryptAll([]byte("wrong"), []interface{}{encrypted}); err == nil {
+		t.Error("expected an error but got nil")
+	}
+}